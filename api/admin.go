@@ -0,0 +1,488 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jpappel/penny/auth"
+	"github.com/jpappel/penny/data"
+)
+
+// How many comments and actions the admin pages list at once
+const adminPageSize = 100
+
+const dateFormat = "2006-01-02"
+
+func adminKey(provider string, email string) string {
+	return strings.ToLower(provider) + ":" + strings.ToLower(email)
+}
+
+// Get the permissions loaded by requireRole, the zero value outside of it
+func getPermissions(r *http.Request) data.Permissions {
+	perms, _ := r.Context().Value(permissionsKey).(data.Permissions)
+	return perms
+}
+
+// Middleware only allowing users with one of roles through, placing their permissions into the request context
+//
+// Roles are read from the database on every request so changes apply immediately.
+func (s *Server) requireRole(next http.Handler, roles ...data.Role) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user := getUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintln(w, "<h1>Error 401</h1><p>You need to be signed in to moderate comments</p>")
+			return
+		}
+
+		perms, err := s.db.GetPermissions(ctx, user.Id)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to get permissions", slog.Any("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+			return
+		} else if !slices.Contains(roles, perms.Role) {
+			slog.WarnContext(ctx, "Rejected admin request",
+				slog.Int64("user", user.Id), slog.String("role", string(perms.Role)), slog.String("url", r.URL.String()))
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, "<h1>Error 403</h1><p>You are not allowed to view this page</p>")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, permissionsKey, perms)))
+	})
+}
+
+// Middleware only allowing administrators through
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return s.requireRole(next, data.RoleAdmin)
+}
+
+// Middleware only allowing administrators and moderators through
+func (s *Server) requireModerator(next http.Handler) http.Handler {
+	return s.requireRole(next, data.RoleAdmin, data.RoleModerator)
+}
+
+// Middleware rejecting requests from banned users, with a json error if asJSON is set
+func (s *Server) notBanned(next http.Handler, asJSON bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if getRole(r) != data.RoleBanned {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if asJSON {
+			writeJSONError(w, http.StatusForbidden, "You are banned from commenting")
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, "<h1>Error 403</h1><p>You are banned from commenting</p>")
+		}
+	})
+}
+
+// Parse the filters for the comments listed on the dashboard
+func parseModerationFilter(query url.Values) (data.ModerationFilter, error) {
+	filter := data.ModerationFilter{
+		Queue:   query.Get("queue"),
+		PageUrl: strings.TrimSpace(query.Get("page")),
+		Limit:   adminPageSize,
+	}
+	if filter.Queue == "recent" {
+		filter.Queue = ""
+	} else if filter.Queue != "" && filter.Queue != "held" && filter.Queue != "reported" {
+		return filter, fmt.Errorf("No queue named %q", filter.Queue)
+	}
+
+	if s := strings.TrimSpace(query.Get("user")); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			return filter, errors.New("Invalid user id")
+		}
+		filter.UserId = id
+	}
+
+	if s := query.Get("since"); s != "" {
+		since, err := time.ParseInLocation(dateFormat, s, time.Local)
+		if err != nil {
+			return filter, errors.New("Invalid start date")
+		}
+		filter.Since = since
+	}
+	if s := query.Get("until"); s != "" {
+		until, err := time.ParseInLocation(dateFormat, s, time.Local)
+		if err != nil {
+			return filter, errors.New("Invalid end date")
+		}
+		// include the whole final day
+		filter.Until = until.AddDate(0, 0, 1)
+	}
+
+	return filter, nil
+}
+
+// List comments across every page for moderators
+func (s *Server) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), "now", time.Now().Unix())
+
+	query := r.URL.Query()
+	filter, err := parseModerationFilter(query)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "<h1>Error 400</h1><p>%s</p>\n", html.EscapeString(err.Error()))
+		return
+	}
+	perms := getPermissions(r)
+	filter.PagePrefixes = perms.PagePrefixes()
+
+	comments, err := s.db.GetModerationComments(ctx, filter)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get comments to moderate", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	}
+
+	d := struct {
+		User        *auth.User
+		Permissions data.Permissions
+		Comments    []data.ModerationComment
+		Query       url.Values
+		Actions     []string
+		Base        string
+		Return      string
+		CSRFToken   string
+	}{
+		User:        getUser(r),
+		Permissions: perms,
+		Comments:    comments,
+		Query:       query,
+		Actions:     data.ModerationActions,
+		Base:        s.base,
+		Return:      r.URL.RequestURI(),
+		CSRFToken:   s.csrfToken(r),
+	}
+	err = tmpls.ExecuteTemplate(w, "admin.html", d)
+	if err != nil {
+		slog.ErrorContext(ctx, "An error occured while executing template", slog.Any("error", err))
+	}
+}
+
+// Apply a moderation action to the selected comments
+func (s *Server) ModerateComments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := getUser(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCommentLen)
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Malformed form data</p>")
+		return
+	}
+
+	action := r.PostForm.Get("action")
+	if !slices.Contains(data.ModerationActions, action) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "<h1>Error 400</h1><p>Unknown action, expected one of %s</p>\n", strings.Join(data.ModerationActions, ", "))
+		return
+	}
+
+	var ids []int64
+	for _, s := range r.PostForm["id"] {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "<h1>Error 400</h1><p>Invalid comment id</p>")
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>No comments selected</p>")
+		return
+	}
+
+	reason := strings.TrimSpace(r.PostForm.Get("reason"))
+	err := s.db.ModerateComments(ctx, user.Id, getPermissions(r).PagePrefixes(), action, ids, reason)
+	if errors.Is(err, data.ErrNoComment) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "<h1>Error 404</h1><p>%s</p>\n", err)
+		return
+	} else if errors.Is(err, data.ErrNotPermitted) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<h1>Error 403</h1><p>%s</p>\n", err)
+		return
+	} else if errors.Is(err, data.ErrCommentPurged) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "<h1>Error 409</h1><p>%s</p>\n", err)
+		return
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Failed to moderate comments", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	}
+	slog.InfoContext(ctx, "Moderated comments",
+		slog.Int64("moderator", user.Id), slog.String("action", action), slog.Any("comments", ids))
+
+	dest := s.safeReturn(r.PostForm.Get("return"))
+	if dest == "" {
+		dest = fmt.Sprint(s.base, "/admin")
+	}
+	http.Redirect(w, r, dest, http.StatusSeeOther)
+}
+
+// A revision and the changes made since the revision before it
+type revisionDiff struct {
+	data.Revision
+	Changes []diffPart
+}
+
+// Show every revision of a comment and what changed between them
+func (s *Server) CommentHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Invalid comment id</p>")
+		return
+	}
+
+	history, err := s.db.GetCommentHistory(ctx, id)
+	if errors.Is(err, data.ErrNoComment) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "<h1>Error 404</h1><p>%s</p>\n", err)
+		return
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Failed to get comment history", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	} else if !getPermissions(r).CanModerate(history.PageUrl) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<h1>Error 403</h1><p>%s</p>\n", data.ErrNotPermitted)
+		return
+	}
+
+	revisions := make([]revisionDiff, len(history.Revisions))
+	previous := ""
+	for i, revision := range history.Revisions {
+		revisions[i] = revisionDiff{revision, diffWords(previous, revision.Content)}
+		previous = revision.Content
+	}
+
+	d := struct {
+		CommentId int64
+		PageUrl   string
+		Revisions []revisionDiff
+		Base      string
+	}{id, history.PageUrl, revisions, s.base}
+	err = tmpls.ExecuteTemplate(w, "history.html", d)
+	if err != nil {
+		slog.ErrorContext(ctx, "An error occured while executing template", slog.Any("error", err))
+	}
+}
+
+// List the most recent moderation actions
+func (s *Server) AuditLog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	actions, err := s.db.GetModerationActions(ctx, adminPageSize)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get moderation actions", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	}
+
+	d := struct {
+		Actions []data.ModerationAction
+		Base    string
+	}{actions, s.base}
+	err = tmpls.ExecuteTemplate(w, "audit.html", d)
+	if err != nil {
+		slog.ErrorContext(ctx, "An error occured while executing template", slog.Any("error", err))
+	}
+}
+
+// List the pages a moderator manages
+func (s *Server) AdminPages(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), "now", time.Now().Unix())
+	perms := getPermissions(r)
+
+	pages, err := s.db.GetPages(ctx, perms.PagePrefixes())
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get pages", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	}
+
+	d := struct {
+		Pages       []data.PageInfo
+		Permissions data.Permissions
+		Base        string
+		CSRFToken   string
+	}{pages, perms, s.base, s.csrfToken(r)}
+	err = tmpls.ExecuteTemplate(w, "admin_pages.html", d)
+	if err != nil {
+		slog.ErrorContext(ctx, "An error occured while executing template", slog.Any("error", err))
+	}
+}
+
+// Open or close comments on a page, opening a page creates it
+func (s *Server) ManagePage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := getUser(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCommentLen)
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Malformed form data</p>")
+		return
+	}
+
+	pageUrl := strings.TrimSpace(r.PostForm.Get("url"))
+	if pageUrl == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Missing page url</p>")
+		return
+	} else if !getPermissions(r).CanModerate(pageUrl) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<h1>Error 403</h1><p>%s</p>\n", data.ErrNotPermitted)
+		return
+	}
+
+	var err error
+	switch action := r.PostForm.Get("action"); action {
+	case "open":
+		// pages without an end date stay open indefinitely
+		until := time.Unix(math.MaxInt64, 0)
+		if s := r.PostForm.Get("until"); s != "" {
+			until, err = time.ParseInLocation(dateFormat, s, time.Local)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintln(w, "<h1>Error 400</h1><p>Invalid end date</p>")
+				return
+			}
+			until = until.AddDate(0, 0, 1)
+		}
+		err = s.db.OpenPage(ctx, pageUrl, until)
+	case "close":
+		err = s.db.ClosePage(ctx, pageUrl)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Unknown action, expected open or close</p>")
+		return
+	}
+	if errors.Is(err, data.ErrNoPage) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "<h1>Error 404</h1><p>%s</p>\n", err)
+		return
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Failed to manage page", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	}
+	slog.InfoContext(ctx, "Managed page",
+		slog.Int64("user", user.Id), slog.String("action", r.PostForm.Get("action")), slog.String("page", pageUrl))
+
+	http.Redirect(w, r, fmt.Sprint(s.base, "/admin/pages"), http.StatusSeeOther)
+}
+
+// List users with a role other than user
+func (s *Server) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	assignments, err := s.db.GetRoleAssignments(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get role assignments", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	}
+
+	d := struct {
+		Assignments []data.RoleAssignment
+		Roles       []data.Role
+		Base        string
+		CSRFToken   string
+	}{assignments, data.Roles, s.base, s.csrfToken(r)}
+	err = tmpls.ExecuteTemplate(w, "admin_users.html", d)
+	if err != nil {
+		slog.ErrorContext(ctx, "An error occured while executing template", slog.Any("error", err))
+	}
+}
+
+// Change a user's role and the pages they may moderate
+func (s *Server) SetRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := getUser(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCommentLen)
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Malformed form data</p>")
+		return
+	}
+
+	userId, err := strconv.ParseInt(strings.TrimSpace(r.PostForm.Get("id")), 10, 64)
+	if err != nil || userId <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Invalid user id</p>")
+		return
+	} else if userId == user.Id {
+		// keeps the last administrator from locking everyone out
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>You cannot change your own role</p>")
+		return
+	}
+
+	role := data.Role(r.PostForm.Get("role"))
+	pages := strings.Fields(r.PostForm.Get("pages"))
+	if role == data.RoleModerator && len(pages) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Moderators need at least one page prefix</p>")
+		return
+	}
+
+	err = s.db.SetRole(ctx, userId, role, pages)
+	if errors.Is(err, data.ErrUnknownRole) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "<h1>Error 400</h1><p>%s, expected one of %s</p>\n", err, joinRoles(data.Roles))
+		return
+	} else if errors.Is(err, data.ErrNoUser) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "<h1>Error 404</h1><p>%s</p>\n", err)
+		return
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Failed to set role", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	}
+	slog.InfoContext(ctx, "Changed role", slog.Int64("admin", user.Id),
+		slog.Int64("user", userId), slog.String("role", string(role)), slog.Any("pages", pages))
+
+	http.Redirect(w, r, fmt.Sprint(s.base, "/admin/users"), http.StatusSeeOther)
+}
+
+func joinRoles(roles []data.Role) string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = string(role)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,363 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jpappel/penny/api"
+)
+
+// Send a request to an admin page with a session
+func requestAdmin(s *api.Server, session *http.Cookie, method string, target string, form url.Values) *httptest.ResponseRecorder {
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	if session != nil {
+		r.AddCookie(session)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminAccess(t *testing.T) {
+	s, pdb := newTestServerConfig(t, api.ServerConfig{
+		BaseUrl:       "penny",
+		SessionSecret: []byte("secret"),
+		Admins:        map[string][]string{"GitHub": {"JP@jpappel.xyz"}},
+	})
+	if _, err := pdb.Db.Exec("INSERT INTO Users(email, provider, name) VALUES (?,?,?)", "jp@jpappel.xyz", "google", "Not JP"); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		session *http.Cookie
+		status  int
+	}{
+		{"SignedOut", nil, http.StatusUnauthorized},
+		{"OtherProvider", signIn(t, s, 2), http.StatusForbidden},
+		{"Admin", signIn(t, s, 1), http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, target := range []string{"/penny/admin", "/penny/admin/audit"} {
+				if w := requestAdmin(s, tc.session, http.MethodGet, target, nil); w.Code != tc.status {
+					t.Errorf("%s: wanted %d got %d\n%s", target, tc.status, w.Code, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestModeration(t *testing.T) {
+	s, pdb := newTestServerConfig(t, api.ServerConfig{
+		BaseUrl:       "penny",
+		SessionSecret: []byte("secret"),
+		Admins:        map[string][]string{"github": {"jp@jpappel.xyz"}},
+	})
+	if _, err := pdb.Db.Exec("INSERT INTO Users(email, provider, name) VALUES (?,?,?)", "b@y.org", "google", "B Y"); err != nil {
+		t.Fatal(err)
+	}
+	admin := signIn(t, s, 1)
+	reader := signIn(t, s, 2)
+
+	for _, content := range []string{"apple pie", "peach cobbler", "pear crumble"} {
+		if w := requestJSON(s, reader, http.MethodPost, "/penny/api/v1/pages/open", `{"content": "`+content+`"}`); w.Code != http.StatusCreated {
+			t.Fatalf("Failed to post comment: %d\n%s", w.Code, w.Body)
+		}
+	}
+	if w := requestJSON(s, admin, http.MethodPost, "/penny/api/v1/comments/2/report", `{"reason": "off topic"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("Failed to report comment: %d\n%s", w.Code, w.Body)
+	}
+	if w := requestJSON(s, admin, http.MethodPost, "/penny/api/v1/comments/2/report", `{"reason": " "}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected report without a reason to fail, got %d", w.Code)
+	}
+
+	w := requestAdmin(s, admin, http.MethodGet, "/penny/admin?queue=reported", nil)
+	if body := w.Body.String(); !strings.Contains(body, "peach cobbler") || strings.Contains(body, "apple pie") || !strings.Contains(body, "1 Reports") {
+		t.Errorf("Expected only the reported comment:\n%s", body)
+	}
+	w = requestAdmin(s, admin, http.MethodGet, "/penny/admin?page=open&user=2&since=2000-01-01", nil)
+	if body := w.Body.String(); !strings.Contains(body, "apple pie") || !strings.Contains(body, "pear crumble") {
+		t.Errorf("Expected filtered comments:\n%s", body)
+	}
+	if w = requestAdmin(s, admin, http.MethodGet, "/penny/admin?since=yesterday", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid date to fail, got %d", w.Code)
+	}
+	w = requestAdmin(s, admin, http.MethodGet, "/penny/admin?queue="+url.QueryEscape("<script>alert(1)</script>"), nil)
+	if body := w.Body.String(); w.Code != http.StatusBadRequest || strings.Contains(body, "<script>") {
+		t.Errorf("Expected unknown queue to fail with an escaped message, got %d\n%s", w.Code, body)
+	}
+
+	form := url.Values{
+		"csrf_token": {csrfToken(t, s, admin)},
+		"action":     {"hide"},
+		"id":         {"1", "3"},
+		"reason":     {"spoilers"},
+		"return":     {"/penny/admin?queue=held"},
+	}
+	w = requestAdmin(s, admin, http.MethodPost, "/penny/admin/comments", form)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/penny/admin?queue=held" {
+		t.Fatalf("Failed to hide comments: %d\n%s", w.Code, w.Body)
+	}
+
+	form.Set("action", "ban")
+	if w = requestAdmin(s, admin, http.MethodPost, "/penny/admin/comments", form); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown action to fail, got %d", w.Code)
+	}
+	form.Set("action", "delete")
+	form["id"] = []string{"100"}
+	if w = requestAdmin(s, admin, http.MethodPost, "/penny/admin/comments", form); w.Code != http.StatusNotFound {
+		t.Errorf("Expected missing comment to fail, got %d", w.Code)
+	}
+	form.Del("csrf_token")
+	form["id"] = []string{"2"}
+	if w = requestAdmin(s, admin, http.MethodPost, "/penny/admin/comments", form); w.Code != http.StatusForbidden {
+		t.Errorf("Expected missing CSRF token to fail, got %d", w.Code)
+	}
+
+	backdateComments(t, pdb)
+	w = requestJSON(s, nil, http.MethodGet, "/penny/api/v1/comments/3", "")
+	if !strings.Contains(w.Body.String(), `"hidden":true`) {
+		t.Errorf("Expected comment to be hidden:\n%s", w.Body)
+	}
+
+	w = requestAdmin(s, admin, http.MethodGet, "/penny/admin/audit", nil)
+	if body := w.Body.String(); strings.Count(body, "<td>hide</td>") != 2 || !strings.Contains(body, "spoilers") {
+		t.Errorf("Expected hidden comments in the audit log:\n%s", body)
+	}
+
+	form.Set("csrf_token", csrfToken(t, s, admin))
+	form.Set("action", "delete")
+	form.Set("reason", "off topic")
+	if w = requestAdmin(s, admin, http.MethodPost, "/penny/admin/comments", form); w.Code != http.StatusSeeOther {
+		t.Fatalf("Failed to delete comment: %d\n%s", w.Code, w.Body)
+	}
+	backdateComments(t, pdb)
+	w = requestAdmin(s, nil, http.MethodGet, "/penny/comments/open", nil)
+	if body := w.Body.String(); strings.Contains(body, "peach cobbler") || !strings.Contains(body, "Reason: off topic") || !strings.Contains(body, "Hidden: spoilers") {
+		t.Errorf("Expected deleted comment without content and moderator reasons:\n%s", body)
+	}
+	w = requestAdmin(s, admin, http.MethodGet, "/penny/admin", nil)
+	if body := w.Body.String(); !strings.Contains(body, "peach cobbler") || !strings.Contains(body, "Removed by moderator: off topic") {
+		t.Errorf("Expected deleted comment content on the dashboard:\n%s", body)
+	}
+
+	form.Set("action", "undelete")
+	form.Set("reason", "")
+	if w = requestAdmin(s, admin, http.MethodPost, "/penny/admin/comments", form); w.Code != http.StatusSeeOther {
+		t.Fatalf("Failed to undelete comment: %d\n%s", w.Code, w.Body)
+	}
+	w = requestJSON(s, nil, http.MethodGet, "/penny/api/v1/comments/2", "")
+	if body := w.Body.String(); !strings.Contains(body, "peach cobbler") || strings.Contains(body, `"reason"`) {
+		t.Errorf("Expected undeleted comment to be restored:\n%s", body)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	s, pdb := newTestServerConfig(t, api.ServerConfig{
+		BaseUrl:       "penny",
+		SessionSecret: []byte("secret"),
+		Admins:        map[string][]string{"github": {"jp@jpappel.xyz"}},
+	})
+	_, err := pdb.Db.Exec("INSERT INTO Users(email, provider, name) VALUES (?,?,?), (?,?,?)",
+		"b@y.org", "google", "B Y", "c@x.net", "github", "C X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pdb.Db.Exec("INSERT INTO Pages(url, commentsOpenTime) VALUES (?,?)", "blog/post", MaxInt64); err != nil {
+		t.Fatal(err)
+	}
+	admin := signIn(t, s, 1)
+	moderator := signIn(t, s, 2)
+	banned := signIn(t, s, 3)
+
+	for _, page := range []string{"open", "blog/post"} {
+		if w := requestJSON(s, banned, http.MethodPost, "/penny/api/v1/pages/"+page, `{"content": "posted on `+page+`"}`); w.Code != http.StatusCreated {
+			t.Fatalf("Failed to post comment: %d\n%s", w.Code, w.Body)
+		}
+	}
+
+	setRole := func(session *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+		form.Set("csrf_token", csrfToken(t, s, session))
+		return requestAdmin(s, session, http.MethodPost, "/penny/admin/users", form)
+	}
+	roleCases := []struct {
+		name    string
+		session *http.Cookie
+		form    url.Values
+		status  int
+	}{
+		{"Moderator", admin, url.Values{"id": {"2"}, "role": {"moderator"}, "pages": {"blog/"}}, http.StatusSeeOther},
+		{"Banned", admin, url.Values{"id": {"3"}, "role": {"banned"}}, http.StatusSeeOther},
+		{"Unknown Role", admin, url.Values{"id": {"3"}, "role": {"owner"}}, http.StatusBadRequest},
+		{"No Pages", admin, url.Values{"id": {"3"}, "role": {"moderator"}}, http.StatusBadRequest},
+		{"Own Role", admin, url.Values{"id": {"1"}, "role": {"user"}}, http.StatusBadRequest},
+		{"Missing User", admin, url.Values{"id": {"100"}, "role": {"admin"}}, http.StatusNotFound},
+		{"Not Admin", moderator, url.Values{"id": {"2"}, "role": {"admin"}}, http.StatusForbidden},
+	}
+	for _, tc := range roleCases {
+		if w := setRole(tc.session, tc.form); w.Code != tc.status {
+			t.Errorf("%s: wanted %d got %d\n%s", tc.name, tc.status, w.Code, w.Body)
+		}
+	}
+
+	w := requestAdmin(s, admin, http.MethodGet, "/penny/admin/users", nil)
+	if body := w.Body.String(); !strings.Contains(body, "<td>moderator</td>") || !strings.Contains(body, "<code>blog/</code>") {
+		t.Errorf("Expected the moderator in the user list:\n%s", body)
+	}
+
+	accessCases := []struct {
+		session *http.Cookie
+		target  string
+		status  int
+	}{
+		{moderator, "/penny/admin", http.StatusOK},
+		{moderator, "/penny/admin/pages", http.StatusOK},
+		{moderator, "/penny/admin/users", http.StatusForbidden},
+		{moderator, "/penny/admin/audit", http.StatusForbidden},
+		{banned, "/penny/admin", http.StatusForbidden},
+		{banned, "/penny/admin/pages", http.StatusForbidden},
+	}
+	for _, tc := range accessCases {
+		if w := requestAdmin(s, tc.session, http.MethodGet, tc.target, nil); w.Code != tc.status {
+			t.Errorf("%s: wanted %d got %d\n%s", tc.target, tc.status, w.Code, w.Body)
+		}
+	}
+
+	w = requestAdmin(s, moderator, http.MethodGet, "/penny/admin", nil)
+	if body := w.Body.String(); !strings.Contains(body, "posted on blog/post") || strings.Contains(body, "posted on open") {
+		t.Errorf("Expected only comments on the moderator's pages:\n%s", body)
+	}
+
+	moderate := func(id string) int {
+		form := url.Values{"csrf_token": {csrfToken(t, s, moderator)}, "action": {"hide"}, "id": {id}}
+		return requestAdmin(s, moderator, http.MethodPost, "/penny/admin/comments", form).Code
+	}
+	if code := moderate("2"); code != http.StatusSeeOther {
+		t.Errorf("Expected moderator to hide a comment on their page, got %d", code)
+	}
+	if code := moderate("1"); code != http.StatusForbidden {
+		t.Errorf("Expected moderator to be denied another page, got %d", code)
+	}
+
+	managePage := func(session *http.Cookie, pageUrl string, action string) int {
+		form := url.Values{"csrf_token": {csrfToken(t, s, session)}, "url": {pageUrl}, "action": {action}}
+		return requestAdmin(s, session, http.MethodPost, "/penny/admin/pages", form).Code
+	}
+	pageCases := []struct {
+		session *http.Cookie
+		pageUrl string
+		action  string
+		status  int
+	}{
+		{moderator, "blog/post", "close", http.StatusSeeOther},
+		{moderator, "blog/new", "open", http.StatusSeeOther},
+		{moderator, "open", "close", http.StatusForbidden},
+		{moderator, "blog/missing", "close", http.StatusNotFound},
+		{moderator, "blog/post", "delete", http.StatusBadRequest},
+		{admin, "closed", "open", http.StatusSeeOther},
+	}
+	for _, tc := range pageCases {
+		if code := managePage(tc.session, tc.pageUrl, tc.action); code != tc.status {
+			t.Errorf("%s %s: wanted %d got %d", tc.action, tc.pageUrl, tc.status, code)
+		}
+	}
+
+	w = requestAdmin(s, moderator, http.MethodGet, "/penny/admin/pages", nil)
+	if body := w.Body.String(); !strings.Contains(body, "blog/new") || strings.Contains(body, ">open<") {
+		t.Errorf("Expected only the moderator's pages:\n%s", body)
+	}
+	if w := requestJSON(s, admin, http.MethodPost, "/penny/api/v1/pages/closed", `{"content": "reopened"}`); w.Code != http.StatusCreated {
+		t.Errorf("Expected reopened page to accept comments, got %d\n%s", w.Code, w.Body)
+	}
+
+	bannedCases := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{http.MethodPost, "/penny/api/v1/pages/open", `{"content": "let me in"}`},
+		{http.MethodPatch, "/penny/api/v1/comments/1", `{"content": "let me in"}`},
+		{http.MethodPost, "/penny/api/v1/comments/2/report", `{"reason": "spite"}`},
+	}
+	for _, tc := range bannedCases {
+		w := requestJSON(s, banned, tc.method, tc.target, tc.body)
+		if _, message := decodeError(t, w); w.Code != http.StatusForbidden || message != "You are banned from commenting" {
+			t.Errorf("%s %s: expected banned user to be rejected, got %d %q", tc.method, tc.target, w.Code, message)
+		}
+	}
+	if w := postComment(t, s, banned, "open", url.Values{"content": {"let me in"}}, false); w.Code != http.StatusForbidden {
+		t.Errorf("Expected banned user's form post to be rejected, got %d", w.Code)
+	}
+}
+
+func TestCommentHistory(t *testing.T) {
+	s, pdb := newTestServerConfig(t, api.ServerConfig{
+		BaseUrl:       "penny",
+		SessionSecret: []byte("secret"),
+		Admins:        map[string][]string{"github": {"jp@jpappel.xyz"}},
+	})
+	if _, err := pdb.Db.Exec("INSERT INTO Users(email, provider, name, role) VALUES (?,?,?,?)", "b@y.org", "google", "B Y", "moderator"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pdb.Db.Exec("INSERT INTO ModeratorPages(userId, prefix) VALUES (2, 'blog/')"); err != nil {
+		t.Fatal(err)
+	}
+	admin := signIn(t, s, 1)
+	moderator := signIn(t, s, 2)
+
+	if w := requestJSON(s, admin, http.MethodPost, "/penny/api/v1/pages/open", `{"content": "apple pie with cream"}`); w.Code != http.StatusCreated {
+		t.Fatalf("Failed to post comment: %d\n%s", w.Code, w.Body)
+	}
+	for _, content := range []string{"apple pie with custard", "peach pie with custard"} {
+		if w := requestJSON(s, admin, http.MethodPatch, "/penny/api/v1/comments/1", `{"content": "`+content+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("Failed to edit comment: %d\n%s", w.Code, w.Body)
+		}
+	}
+
+	w := requestAdmin(s, nil, http.MethodGet, "/penny/comments/open", nil)
+	if body := w.Body.String(); !strings.Contains(body, `class="pennyEdited"`) || !strings.Contains(body, "peach pie with custard") {
+		t.Errorf("Expected edited marker on the comment:\n%s", body)
+	}
+	w = requestAdmin(s, admin, http.MethodGet, "/penny/admin", nil)
+	if body := w.Body.String(); !strings.Contains(body, `href="/penny/admin/comments/1/history"`) {
+		t.Errorf("Expected link to the comment history:\n%s", body)
+	}
+
+	w = requestAdmin(s, admin, http.MethodGet, "/penny/admin/comments/1/history", nil)
+	body := w.Body.String()
+	for _, want := range []string{
+		"<ins>apple pie with cream</ins>",
+		"apple pie with <del>cream</del><ins>custard</ins>",
+		"<del>apple</del><ins>peach</ins> pie with custard",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected history to contain %s:\n%s", want, body)
+		}
+	}
+
+	statusCases := []struct {
+		session *http.Cookie
+		target  string
+		status  int
+	}{
+		{moderator, "/penny/admin/comments/1/history", http.StatusForbidden},
+		{admin, "/penny/admin/comments/100/history", http.StatusNotFound},
+		{admin, "/penny/admin/comments/pie/history", http.StatusBadRequest},
+	}
+	for _, tc := range statusCases {
+		if w := requestAdmin(s, tc.session, http.MethodGet, tc.target, nil); w.Code != tc.status {
+			t.Errorf("%s: wanted %d got %d\n%s", tc.target, tc.status, w.Code, w.Body)
+		}
+	}
+}
@@ -2,22 +2,24 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"html"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jpappel/penny/auth"
 	"github.com/jpappel/penny/data"
+	"github.com/jpappel/penny/filters"
 )
 
-const DB_FILE = "file:data.sqlite3"
-
-func ListPages(w http.ResponseWriter, r *http.Request) {
+func (s *Server) ListPages(w http.ResponseWriter, r *http.Request) {
 	ctx := context.WithValue(r.Context(), "now", time.Now().Unix())
-	pdb := data.New(DB_FILE)
 
-	pageInfos, err := pdb.GetPagesInfo(ctx)
+	pageInfos, err := s.db.GetPagesInfo(ctx)
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to get info on pages", slog.String("error", err.Error()))
 		w.WriteHeader(http.StatusInternalServerError)
@@ -31,19 +33,17 @@ func ListPages(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func GetComments(w http.ResponseWriter, r *http.Request) {
-	// TODO: reuse db connection
-	pdb := data.New(DB_FILE)
+func (s *Server) GetComments(w http.ResponseWriter, r *http.Request) {
 	pageUrl := r.PathValue("pageUrl")
 
 	slog.Info("fetching coments for page", slog.Any("pageUrl", pageUrl))
 
 	ctx := context.WithValue(r.Context(), "now", time.Now().Unix())
 
-	page, err := pdb.GetPageComments(ctx, pageUrl)
+	page, err := s.db.GetPageComments(ctx, pageUrl)
 	if err == data.ErrNoPage {
 		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(w, "<h1>Error 404</h1><p>Comments for page %s not found</p>\n", pageUrl)
+		fmt.Fprintf(w, "<h1>Error 404</h1><p>Comments for page %s not found</p>\n", html.EscapeString(pageUrl))
 		return
 	} else if err != nil {
 		slog.ErrorContext(ctx, "Failed to get Page Comments", slog.Any("error", err))
@@ -59,51 +59,171 @@ func GetComments(w http.ResponseWriter, r *http.Request) {
 
 }
 
-// TODO: parse form data
-func PostComment(w http.ResponseWriter, r *http.Request) {
-	// pageUrl := r.PathValue("pageUrl")
-	// TODO: extract user from request and add to context
+const maxCommentLen = 1 << 14
 
-	r.ParseForm()
-	// comment := r.Form.Get("commentText")
-	// TODO: run filters over text
+var errEmptyComment error = errors.New("Comments cannot be empty")
+var errCommentTooLong error = fmt.Errorf("Comments must be at most %d bytes", maxCommentLen)
+var errCommentRejected error = errors.New("Comment rejected")
+var errEditWindowPassed error = errors.New("The time to edit this comment has passed")
 
-	// pdb := data.New(DB_FILE)
-	// pdb.PostComment(r.Context(), pageUrl, "", comment, nil)
+// Comment text ready to be stored
+type preparedComment struct {
+	Content string
+	HTML    string
+	// why the filters held the comment for moderation, empty if it was not held
+	HeldReason string
 }
 
-func NewComment(w http.ResponseWriter, r *http.Request) {
-	// TODO: extract user from request and add to context
-	d := struct {
-		User      *auth.User
-		Providers []auth.Provider
-	}{
-		User: &auth.User{Name: "JP Appel", Email: "jp@jpappel.xyz"},
-		Providers: []auth.Provider{
-			{Name: "GitHub", Url: "https://github.com"},
-		},
+// Validate comment text and render it before it is stored
+//
+// Comments rejected by the filters return an error wrapping errCommentRejected with the filter's reason.
+func (s *Server) prepareComment(ctx context.Context, text string) (preparedComment, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return preparedComment{}, errEmptyComment
+	} else if len(text) > maxCommentLen {
+		return preparedComment{}, errCommentTooLong
 	}
-	err := tmpls.ExecuteTemplate(w, "new_comment.html", d)
+
+	rendering, err := s.renderer.Review(text)
 	if err != nil {
-		slog.ErrorContext(r.Context(), "An error occured while executing template", slog.Any("error", err))
+		return preparedComment{}, err
+	}
+
+	switch rendering.Verdict {
+	case filters.Reject:
+		slog.InfoContext(ctx, "Comment rejected by filters", slog.String("reason", rendering.Reason))
+		return preparedComment{}, fmt.Errorf("%w: %s", errCommentRejected, rendering.Reason)
+	case filters.Hold:
+		slog.InfoContext(ctx, "Comment held by filters", slog.String("reason", rendering.Reason))
+		return preparedComment{text, rendering.HTML, rendering.Reason}, nil
+	default:
+		return preparedComment{text, rendering.HTML, ""}, nil
 	}
 }
 
-func NewMux(baseUrl string) *http.ServeMux {
-	var base string
-	if baseUrl == "" {
-		base = ""
-	} else {
-		base = fmt.Sprint("/", baseUrl)
+// Store a prepared comment, queueing it for moderation if it was held
+func (s *Server) postComment(ctx context.Context, pageUrl string, userId int64, c preparedComment, parentId *int64) (int, error) {
+	if c.HeldReason != "" {
+		return s.db.PostHeldComment(ctx, pageUrl, userId, c.Content, c.HTML, parentId, c.HeldReason)
+	}
+	return s.db.PostComment(ctx, pageUrl, userId, c.Content, c.HTML, parentId)
+}
+
+// Report if a request was made by a script rather than a browser navigation
+func isFetch(r *http.Request) bool {
+	mode := r.Header.Get("Sec-Fetch-Mode")
+	return mode != "" && mode != "navigate"
+}
+
+// Post a new comment, filtering its text with the server's filters
+func (s *Server) PostComment(w http.ResponseWriter, r *http.Request) {
+	pageUrl := r.PathValue("pageUrl")
+	ctx := context.WithValue(r.Context(), "now", time.Now().Unix())
+
+	user := getUser(r)
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "<h1>Error 401</h1><p>You need to be signed in to comment</p>")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4*maxCommentLen)
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Malformed form data</p>")
+		return
+	}
+
+	var parentId *int64
+	if s := strings.TrimSpace(r.PostForm.Get("parentId")); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "<h1>Error 400</h1><p>Invalid reply id</p>")
+			return
+		}
+		parentId = &id
+	}
+
+	prepared, err := s.prepareComment(ctx, r.PostForm.Get("commentText"))
+	if errors.Is(err, errEmptyComment) || errors.Is(err, errCommentTooLong) || errors.Is(err, errCommentRejected) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "<h1>Error 400</h1><p>%s</p>\n", html.EscapeString(err.Error()))
+		return
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Failed to render comment", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
 	}
-	mux := http.NewServeMux()
 
-	logger := slog.Default()
+	id, err := s.postComment(ctx, pageUrl, user.Id, prepared, parentId)
+	switch err {
+	case nil:
+	case data.ErrNoPage:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "<h1>Error 404</h1><p>Page %s not found</p>\n", html.EscapeString(pageUrl))
+		return
+	case data.ErrPageClosed:
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<h1>Error 403</h1><p>Comments for page %s are closed</p>\n", html.EscapeString(pageUrl))
+		return
+	case data.ErrNoParent:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "<h1>Error 400</h1><p>No comment %d to reply to</p>\n", *parentId)
+		return
+	case data.ErrNoUser:
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "<h1>Error 401</h1><p>You need to be signed in to comment</p>")
+		return
+	default:
+		slog.ErrorContext(ctx, "Failed to post comment", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	}
+
+	if !isFetch(r) {
+		http.Redirect(w, r, fmt.Sprintf("%s/comments/%s#pennyComment_%d", s.base, pageUrl, id), http.StatusSeeOther)
+		return
+	}
+
+	comment, err := s.db.GetCommentById(ctx, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get posted comment", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	}
 
-	mux.HandleFunc(fmt.Sprint("/", baseUrl), ListPages)
-	mux.HandleFunc(fmt.Sprint(base, "/comments/{pageUrl...}"), GetComments)
-	mux.Handle(fmt.Sprintf("GET %s/new/comments/{pageUrl...}", base), Log(http.HandlerFunc(NewComment), logger))
-	mux.Handle(fmt.Sprintf("POST %s/new/comments/{pageUrl...}", base), Log(http.HandlerFunc(PostComment), logger))
+	if comment.Held {
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	err = tmpls.ExecuteTemplate(w, "comment.html", comment)
+	if err != nil {
+		slog.ErrorContext(ctx, "An error occured while executing template", slog.Any("error", err))
+	}
+}
 
-	return mux
+func (s *Server) NewComment(w http.ResponseWriter, r *http.Request) {
+	d := struct {
+		User       *auth.User
+		Providers  []auth.Provider
+		SignOutUrl string
+		Return     string
+		CSRFToken  string
+	}{
+		User:       getUser(r),
+		Providers:  s.signInProviders(r.URL.Path),
+		SignOutUrl: fmt.Sprint(s.base, "/auth/logout"),
+		Return:     r.URL.Path,
+		CSRFToken:  s.csrfToken(r),
+	}
+	err := tmpls.ExecuteTemplate(w, "new_comment.html", d)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "An error occured while executing template", slog.Any("error", err))
+	}
 }
@@ -0,0 +1,195 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jpappel/penny/api"
+	"github.com/jpappel/penny/data"
+)
+
+const MaxInt64 int64 = 1<<63 - 1
+
+// server with an open and closed page
+func newTestServer(t *testing.T) (*api.Server, data.PennyDB) {
+	return newTestServerConfig(t, api.ServerConfig{BaseUrl: "penny", SessionSecret: []byte("secret")})
+}
+
+func newTestServerConfig(t *testing.T, cfg api.ServerConfig) (*api.Server, data.PennyDB) {
+	t.Helper()
+	pdb := data.New(fmt.Sprintf("file:%s/api.db", t.TempDir()))
+
+	_, err := pdb.Db.Exec("INSERT INTO Users(email, provider, name) VALUES (?,?,?)", "jp@jpappel.xyz", "github", "JP Appel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pdb.Db.Exec("INSERT INTO Pages(url, commentsOpenTime) VALUES (?,?), (?,NULL)", "open", MaxInt64, "closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := api.NewServer(pdb, cfg)
+	t.Cleanup(func() { s.Close() })
+	return s, pdb
+}
+
+// Start a session for a user, returning its cookie
+func signIn(t *testing.T, s *api.Server, userId int64) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := s.SignIn(w, httptest.NewRequest(http.MethodGet, "/", nil), userId); err != nil {
+		t.Fatal("Failed to sign in:", err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected a single session cookie, got %v\n", cookies)
+	}
+	return cookies[0]
+}
+
+// Move hide and delete times a second into the past
+//
+// Comments are only hidden or deleted once the second they were changed in has passed.
+func backdateComments(t *testing.T, pdb data.PennyDB) {
+	t.Helper()
+	_, err := pdb.Db.Exec("UPDATE Comments SET hiddenTime = hiddenTime - 1, deletedTime = deletedTime - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var csrfInput = regexp.MustCompile(`name="csrf_token" value="([^"]*)"`)
+
+// Get the CSRF token from the new comment form
+func csrfToken(t *testing.T, s *api.Server, session *http.Cookie) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/penny/new/comments/open", nil)
+	r.AddCookie(session)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	m := csrfInput.FindStringSubmatch(w.Body.String())
+	if m == nil || m[1] == "" {
+		t.Fatalf("Missing CSRF token in form:\n%s", w.Body)
+	}
+	return m[1]
+}
+
+// Post a comment with the session's CSRF token
+func postComment(t *testing.T, s *api.Server, session *http.Cookie, pageUrl string, form url.Values, fetch bool) *httptest.ResponseRecorder {
+	t.Helper()
+	if session != nil {
+		form.Set("csrf_token", csrfToken(t, s, session))
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/penny/new/comments/"+pageUrl, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if session != nil {
+		r.AddCookie(session)
+	}
+	if fetch {
+		r.Header.Set("Sec-Fetch-Mode", "cors")
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestPostComment(t *testing.T) {
+	testCases := []struct {
+		name     string
+		pageUrl  string
+		form     url.Values
+		fetch    bool
+		status   int
+		location string
+	}{
+		{"Redirect", "open", url.Values{"commentText": {"pie"}}, false, http.StatusSeeOther, "/penny/comments/open#pennyComment_1"},
+		{"Fetch", "open", url.Values{"commentText": {"pie"}}, true, http.StatusCreated, ""},
+		{"Empty", "open", url.Values{"commentText": {"  "}}, false, http.StatusBadRequest, ""},
+		{"TooLong", "open", url.Values{"commentText": {strings.Repeat("a", 1<<14+1)}}, false, http.StatusBadRequest, ""},
+		{"InvalidParentId", "open", url.Values{"commentText": {"pie"}, "parentId": {"first"}}, false, http.StatusBadRequest, ""},
+		{"MissingParent", "open", url.Values{"commentText": {"pie"}, "parentId": {"7"}}, false, http.StatusBadRequest, ""},
+		{"ClosedPage", "closed", url.Values{"commentText": {"pie"}}, false, http.StatusForbidden, ""},
+		{"MissingPage", "I/do/not/exist", url.Values{"commentText": {"pie"}}, false, http.StatusNotFound, ""},
+		{"ScriptPage", url.PathEscape("<script>alert(1)</script>"), url.Values{"commentText": {"pie"}}, false, http.StatusNotFound, ""},
+		{"SignedOut", "open", url.Values{"commentText": {"pie"}}, false, http.StatusUnauthorized, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newTestServer(t)
+			var session *http.Cookie
+			if tc.name != "SignedOut" {
+				session = signIn(t, s, 1)
+			}
+			w := postComment(t, s, session, tc.pageUrl, tc.form, tc.fetch)
+			if w.Code != tc.status {
+				t.Fatalf("Unexpected status: wanted %d got %d\n%s", tc.status, w.Code, w.Body)
+			}
+			if strings.Contains(w.Body.String(), "<script") {
+				t.Errorf("Expected page url to be escaped, got:\n%s", w.Body)
+			}
+			if loc := w.Header().Get("Location"); loc != tc.location {
+				t.Errorf("Unexpected redirect: wanted `%s` got `%s`\n", tc.location, loc)
+			}
+			if tc.fetch && !strings.Contains(w.Body.String(), `id="pennyComment_1"`) {
+				t.Errorf("Expected rendered comment, got:\n%s", w.Body)
+			}
+		})
+	}
+}
+
+func TestPostReplies(t *testing.T) {
+	s, pdb := newTestServer(t)
+	session := signIn(t, s, 1)
+
+	posts := []url.Values{
+		{"commentText": {"cobbler"}},
+		{"commentText": {"with"}, "parentId": {"1"}},
+		{"commentText": {"icecream"}, "parentId": {"2"}},
+		{"commentText": {"apple"}},
+		{"commentText": {"pie"}, "parentId": {"4"}},
+	}
+	for _, form := range posts {
+		if w := postComment(t, s, session, "open", form, false); w.Code != http.StatusSeeOther {
+			t.Fatalf("Failed to post %v: %d\n%s", form, w.Code, w.Body)
+		}
+	}
+
+	var depths []int
+	rows, err := pdb.Db.Query("SELECT childId, depth FROM Replies ORDER BY childId")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id, depth int
+		if err := rows.Scan(&id, &depth); err != nil {
+			t.Fatal(err)
+		}
+		depths = append(depths, id, depth)
+	}
+	rows.Close()
+
+	expected := []int{2, 1, 3, 2, 5, 1}
+	if fmt.Sprint(depths) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected replies: wanted %v got %v\n", expected, depths)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/penny/comments/open", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status: wanted %d got %d\n", http.StatusOK, w.Code)
+	}
+	for i := 1; i <= len(posts); i++ {
+		if !strings.Contains(w.Body.String(), fmt.Sprintf(`id="pennyComment_%d"`, i)) {
+			t.Errorf("Missing comment %d in page", i)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jpappel/penny/auth"
+	"github.com/jpappel/penny/data"
+	"golang.org/x/oauth2"
+)
+
+const oauthCookie = "penny_oauth"
+
+// Generate a random url safe token with n bytes of entropy
+func randomToken(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Only allow redirects to paths on this host or pages on a trusted origin
+func (s *Server) safeReturn(ret string) string {
+	if strings.HasPrefix(ret, "/") && !strings.HasPrefix(ret, "//") && !strings.HasPrefix(ret, "/\\") {
+		return ret
+	}
+
+	u, err := url.Parse(ret)
+	if err != nil || !s.trustedOrigin(fmt.Sprintf("%s://%s", u.Scheme, u.Host)) {
+		return ""
+	}
+	return ret
+}
+
+// Get the provider named in a request's path
+func (s *Server) getProvider(w http.ResponseWriter, r *http.Request) *auth.OAuthProvider {
+	key := r.PathValue("provider")
+	p, ok := s.providers[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "<h1>Error 404</h1><p>No provider %s</p>\n", html.EscapeString(key))
+		return nil
+	}
+	return p
+}
+
+// Sign in options for the configured providers, returning to ret afterwards
+func (s *Server) signInProviders(ret string) []auth.Provider {
+	providers := make([]auth.Provider, 0, len(s.providerOrder))
+	for _, p := range s.providerOrder {
+		u := fmt.Sprintf("%s/auth/%s/login", s.base, p.Key)
+		if ret != "" {
+			u += "?return=" + url.QueryEscape(ret)
+		}
+		providers = append(providers, auth.Provider{Name: p.Name, Url: u})
+	}
+	return providers
+}
+
+// Redirect to a provider's authorization page
+func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
+	p := s.getProvider(w, r)
+	if p == nil {
+		return
+	}
+
+	state := randomToken(32)
+	verifier := oauth2.GenerateVerifier()
+	ret := base64.RawURLEncoding.EncodeToString([]byte(s.safeReturn(r.URL.Query().Get("return"))))
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookie,
+		Value:    strings.Join([]string{state, verifier, ret}, "."),
+		Path:     fmt.Sprintf("%s/auth/%s/", s.base, p.Key),
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, p.Config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+}
+
+// Complete a provider's authorization and sign in the user
+func (s *Server) Callback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p := s.getProvider(w, r)
+	if p == nil {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   oauthCookie,
+		Path:   fmt.Sprintf("%s/auth/%s/", s.base, p.Key),
+		MaxAge: -1,
+		Secure: s.secureCookies,
+	})
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		slog.InfoContext(ctx, "Authorization denied", slog.String("provider", p.Key), slog.String("error", e))
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(w, "<h1>Error 401</h1><p>Sign in with %s failed</p>\n", p.Name)
+		return
+	}
+
+	cookie, err := r.Cookie(oauthCookie)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Sign in expired, please try again</p>")
+		return
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	state := query.Get("state")
+	if len(parts) != 3 || state == "" || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(state)) != 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Invalid sign in state</p>")
+		return
+	}
+	verifier := parts[1]
+	ret, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		ret = nil
+	}
+
+	user, err := p.Authenticate(ctx, query.Get("code"), verifier)
+	if err == auth.ErrNoEmail {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<h1>Error 403</h1><p>Your %s account needs a verified email address</p>\n", p.Name)
+		return
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Failed to authenticate user", slog.String("provider", p.Key), slog.Any("error", err))
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintf(w, "<h1>Error 502</h1><p>Unable to sign in with %s</p>\n", p.Name)
+		return
+	}
+
+	userId, err := s.db.UpsertUser(ctx, user.Email, user.Provider, user.Name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save user", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	}
+
+	if s.admins[adminKey(user.Provider, user.Email)] {
+		if err := s.db.SetRole(ctx, userId, data.RoleAdmin, nil); err != nil {
+			slog.ErrorContext(ctx, "Failed to grant configured admin", slog.Any("error", err))
+		}
+	}
+
+	if err := s.SignIn(w, r, userId); err != nil {
+		slog.ErrorContext(ctx, "Failed to start session", slog.Any("error", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+		return
+	}
+
+	dest := s.safeReturn(string(ret))
+	if dest == "" {
+		dest = fmt.Sprint(s.base, "/")
+	}
+	http.Redirect(w, r, dest, http.StatusSeeOther)
+}
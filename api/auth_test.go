@@ -0,0 +1,115 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jpappel/penny/api"
+	"github.com/jpappel/penny/auth"
+	"github.com/jpappel/penny/data"
+)
+
+func newAuthServer(t *testing.T) *api.Server {
+	return newAuthServerConfig(t, api.ServerConfig{BaseUrl: "penny"})
+}
+
+func newAuthServerConfig(t *testing.T, cfg api.ServerConfig) *api.Server {
+	t.Helper()
+	github, err := auth.NewOAuthProvider("GitHub", "id", "secret", "http://localhost:8080/penny/auth/github/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pdb := data.New(fmt.Sprintf("file:%s/auth.db", t.TempDir()))
+	cfg.Providers = []*auth.OAuthProvider{github}
+	s := api.NewServer(pdb, cfg)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestLogin(t *testing.T) {
+	s := newAuthServer(t)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/penny/auth/myspace/login", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status for unknown provider: wanted %d got %d\n", http.StatusNotFound, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/penny/auth/"+url.PathEscape("<script>")+"/login", nil))
+	if body := w.Body.String(); w.Code != http.StatusNotFound || strings.Contains(body, "<script>") {
+		t.Errorf("Expected unknown provider to be escaped, got %d\n%s", w.Code, body)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/penny/auth/github/login?return=/penny/new/comments/apples", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Unexpected status: wanted %d got %d\n", http.StatusFound, w.Code)
+	}
+
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Host != "github.com" {
+		t.Errorf("Unexpected redirect host: %s\n", loc.Host)
+	}
+	query := loc.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Error("Missing PKCE challenge in redirect:", loc)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("Expected a single HttpOnly cookie, got %v\n", cookies)
+	}
+	if state := query.Get("state"); state == "" || !strings.HasPrefix(cookies[0].Value, state+".") {
+		t.Errorf("State `%s` does not match cookie `%s`\n", state, cookies[0].Value)
+	}
+}
+
+func TestLoginSecureCookie(t *testing.T) {
+	// TLS is terminated by a proxy so the request itself is plain http
+	s := newAuthServerConfig(t, api.ServerConfig{BaseUrl: "penny", SecureCookies: true})
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/penny/auth/github/login", nil))
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || !cookies[0].Secure {
+		t.Errorf("Expected a single Secure cookie, got %v\n", cookies)
+	}
+}
+
+func TestCallbackState(t *testing.T) {
+	s := newAuthServer(t)
+
+	testCases := []struct {
+		name   string
+		query  string
+		cookie string
+		status int
+	}{
+		{"MissingCookie", "?state=abc&code=123", "", http.StatusBadRequest},
+		{"MismatchedState", "?state=abc&code=123", "xyz.verifier.", http.StatusBadRequest},
+		{"MissingState", "?code=123", "abc.verifier.", http.StatusBadRequest},
+		{"Denied", "?error=access_denied&state=abc", "abc.verifier.", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/penny/auth/github/callback"+tc.query, nil)
+			if tc.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "penny_oauth", Value: tc.cookie})
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Errorf("Unexpected status: wanted %d got %d\n", tc.status, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	s, _ := newTestServer(t)
+	session := signIn(t, s, 1)
+	token := csrfToken(t, s, session)
+
+	testCases := []struct {
+		name    string
+		body    string
+		headers map[string]string
+		status  int
+	}{
+		{"MissingToken", url.Values{"commentText": {"pie"}}.Encode(), nil, http.StatusForbidden},
+		{"WrongToken", url.Values{"commentText": {"pie"}, "csrf_token": {"abc"}}.Encode(), nil, http.StatusForbidden},
+		{"FormToken", url.Values{"commentText": {"pie"}, "csrf_token": {token}}.Encode(), nil, http.StatusSeeOther},
+		{"OversizedForm",
+			url.Values{"commentText": {"pie"}, "csrf_token": {token}, "padding": {strings.Repeat("pie ", 1<<15)}}.Encode(),
+			nil, http.StatusBadRequest},
+		{"HeaderToken", url.Values{"commentText": {"pie"}}.Encode(), map[string]string{"X-CSRF-Token": token}, http.StatusSeeOther},
+		{"CrossSiteForm",
+			url.Values{"commentText": {"pie"}}.Encode(),
+			map[string]string{"Origin": "https://evil.example.com", "Sec-Fetch-Site": "cross-site"},
+			http.StatusForbidden},
+		// JSON bodies aren't parsed as forms, so passing the check leaves an empty comment
+		{"SameOriginJSON", `{}`,
+			map[string]string{"Content-Type": "application/json", "Sec-Fetch-Site": "same-origin"},
+			http.StatusBadRequest},
+		{"SameHostJSON", `{}`,
+			map[string]string{"Content-Type": "application/json", "Origin": "http://example.com"},
+			http.StatusBadRequest},
+		{"CrossSiteJSON", `{}`,
+			map[string]string{"Content-Type": "application/json", "Origin": "https://evil.example.com", "Sec-Fetch-Site": "cross-site"},
+			http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/penny/new/comments/open", strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			r.AddCookie(session)
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Errorf("Unexpected status: wanted %d got %d\n%s", tc.status, w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Most token pairs compared when diffing, larger changes replace the whole text
+const maxDiffCells = 1 << 20
+
+type diffOp int
+
+const (
+	diffEqual diffOp = iota
+	diffInsert
+	diffDelete
+)
+
+// A run of text kept, inserted or deleted between two revisions
+type diffPart struct {
+	Op   diffOp
+	Text string
+}
+
+func (p diffPart) Equal() bool  { return p.Op == diffEqual }
+func (p diffPart) Insert() bool { return p.Op == diffInsert }
+func (p diffPart) Delete() bool { return p.Op == diffDelete }
+
+// Split text into alternating runs of whitespace and everything else
+func diffTokens(text string) []string {
+	var tokens []string
+	start := 0
+	space := false
+	for i, r := range text {
+		if i > 0 && unicode.IsSpace(r) != space {
+			tokens = append(tokens, text[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(text) {
+		tokens = append(tokens, text[start:])
+	}
+	return tokens
+}
+
+// Find the words inserted and deleted to turn old into new
+func diffWords(old string, new string) []diffPart {
+	a, b := diffTokens(old), diffTokens(new)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var parts []diffPart
+	add := func(op diffOp, tokens ...string) {
+		text := strings.Join(tokens, "")
+		if text == "" {
+			return
+		} else if n := len(parts); n > 0 && parts[n-1].Op == op {
+			parts[n-1].Text += text
+			return
+		}
+		parts = append(parts, diffPart{op, text})
+	}
+
+	add(diffEqual, a[:prefix]...)
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(am)*len(bm) > maxDiffCells {
+		add(diffDelete, am...)
+		add(diffInsert, bm...)
+	} else {
+		// longest common subsequence of the changed middle
+		lcs := make([][]int, len(am)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(bm)+1)
+		}
+		for i := len(am) - 1; i >= 0; i-- {
+			for j := len(bm) - 1; j >= 0; j-- {
+				if am[i] == bm[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(am) && j < len(bm) {
+			switch {
+			case am[i] == bm[j]:
+				add(diffEqual, am[i])
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				add(diffDelete, am[i])
+				i++
+			default:
+				add(diffInsert, bm[j])
+				j++
+			}
+		}
+		add(diffDelete, am[i:]...)
+		add(diffInsert, bm[j:]...)
+	}
+	add(diffEqual, a[len(a)-suffix:]...)
+
+	return parts
+}
@@ -0,0 +1,16 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed static/embed.js
+var embedScript []byte
+
+// Serve the script that embeds penny into other sites
+func (s *Server) EmbedScript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(embedScript)
+}
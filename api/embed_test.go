@@ -0,0 +1,83 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jpappel/penny/api"
+)
+
+func TestEmbedScript(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/penny/embed.js", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status: wanted %d got %d\n", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/javascript") {
+		t.Errorf("Unexpected content type: %s\n", ct)
+	}
+	if !strings.Contains(w.Body.String(), "pennyComment") {
+		t.Error("Embed script is missing penny classes")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	s, _ := newTestServerConfig(t, api.ServerConfig{
+		BaseUrl:        "penny",
+		SessionSecret:  []byte("secret"),
+		SecureCookies:  true,
+		TrustedOrigins: []string{"https://blog.example.com"},
+	})
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodOptions, "/penny/api/v1/pages/open", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := preflight("https://blog.example.com")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Unexpected preflight status: wanted %d got %d\n", http.StatusNoContent, w.Code)
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://blog.example.com" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+		!strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "X-CSRF-Token") {
+		t.Errorf("Missing CORS headers: %v\n", w.Header())
+	}
+
+	if w := preflight("https://evil.example.com"); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("Untrusted origin was allowed")
+	}
+
+	session := signIn(t, s, 1)
+	if session.SameSite != http.SameSiteNoneMode || !session.Secure {
+		t.Errorf("Expected a secure cross site session cookie, got %v\n", session)
+	}
+
+	testCases := []struct {
+		ret      string
+		location string
+	}{
+		{"https://blog.example.com/posts/apples", "https://blog.example.com/posts/apples"},
+		{"https://evil.example.com/posts/apples", "/penny/"},
+		{"//evil.example.com", "/penny/"},
+	}
+	for _, tc := range testCases {
+		form := url.Values{"return": {tc.ret}}
+		r := httptest.NewRequest(http.MethodPost, "/penny/auth/logout", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if loc := w.Header().Get("Location"); loc != tc.location {
+			t.Errorf("Unexpected redirect for %s: wanted %s got %s\n", tc.ret, tc.location, loc)
+		}
+	}
+}
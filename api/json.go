@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jpappel/penny/data"
+)
+
+type jsonError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+type commentRequest struct {
+	Content  string `json:"content"`
+	ParentId *int64 `json:"parentId,omitempty"`
+}
+
+type reportRequest struct {
+	Reason string `json:"reason"`
+}
+
+const maxReportLen = 1 << 10
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to encode json response", slog.Any("error", err))
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, struct {
+		Error jsonError `json:"error"`
+	}{jsonError{status, message}})
+}
+
+// Map an error from handling a request to a json error response
+func handleErrorJSON(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, data.ErrNoPage):
+		writeJSONError(w, http.StatusNotFound, data.ErrNoPage.Error())
+	case errors.Is(err, data.ErrNoComment), errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, http.StatusNotFound, data.ErrNoComment.Error())
+	case errors.Is(err, data.ErrPageClosed):
+		writeJSONError(w, http.StatusForbidden, data.ErrPageClosed.Error())
+	case errors.Is(err, data.ErrNotAuthor):
+		writeJSONError(w, http.StatusForbidden, data.ErrNotAuthor.Error())
+	case errors.Is(err, errEditWindowPassed):
+		writeJSONError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, data.ErrNoParent):
+		writeJSONError(w, http.StatusBadRequest, data.ErrNoParent.Error())
+	case errors.Is(err, data.ErrNoUser):
+		writeJSONError(w, http.StatusUnauthorized, "You need to be signed in")
+	case errors.Is(err, errEmptyComment), errors.Is(err, errCommentTooLong), errors.Is(err, errCommentRejected):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	default:
+		slog.ErrorContext(ctx, "Failed to handle api request", slog.Any("error", err))
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 4*maxCommentLen)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprint("Malformed request body: ", err))
+		return false
+	}
+	return true
+}
+
+// Get the comment id in a request's path
+func commentIdJSON(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid comment id")
+		return 0, false
+	}
+	return id, true
+}
+
+// Check the signed in user wrote a comment, responding with an error if not
+func (s *Server) authorJSON(ctx context.Context, w http.ResponseWriter, r *http.Request, commentId int64) bool {
+	user := getUser(r)
+	if user == nil {
+		writeJSONError(w, http.StatusUnauthorized, "You need to be signed in")
+		return false
+	}
+
+	authorId, err := s.db.GetCommentAuthor(ctx, commentId)
+	if err != nil {
+		handleErrorJSON(ctx, w, err)
+		return false
+	} else if authorId != user.Id {
+		writeJSONError(w, http.StatusForbidden, data.ErrNotAuthor.Error())
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) ListPagesJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), "now", time.Now().Unix())
+
+	pageInfos, err := s.db.GetPagesInfo(ctx)
+	if err != nil {
+		handleErrorJSON(ctx, w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pageInfos)
+}
+
+func (s *Server) GetPageJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), "now", time.Now().Unix())
+
+	page, err := s.db.GetPageComments(ctx, r.PathValue("pageUrl"))
+	if err != nil {
+		handleErrorJSON(ctx, w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) GetCommentJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), "now", time.Now().Unix())
+	id, ok := commentIdJSON(w, r)
+	if !ok {
+		return
+	}
+
+	comment, err := s.db.GetCommentById(ctx, int(id))
+	if err != nil {
+		handleErrorJSON(ctx, w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, comment)
+}
+
+func (s *Server) PostCommentJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), "now", time.Now().Unix())
+	pageUrl := r.PathValue("pageUrl")
+
+	user := getUser(r)
+	if user == nil {
+		writeJSONError(w, http.StatusUnauthorized, "You need to be signed in")
+		return
+	}
+
+	var req commentRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	prepared, err := s.prepareComment(ctx, req.Content)
+	if err != nil {
+		handleErrorJSON(ctx, w, err)
+		return
+	}
+
+	id, err := s.postComment(ctx, pageUrl, user.Id, prepared, req.ParentId)
+	if err != nil {
+		handleErrorJSON(ctx, w, err)
+		return
+	}
+
+	comment, err := s.db.GetCommentById(ctx, id)
+	if err != nil {
+		handleErrorJSON(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s/api/v1/comments/%d", s.base, id))
+	if comment.Held {
+		writeJSON(w, http.StatusAccepted, comment)
+	} else {
+		writeJSON(w, http.StatusCreated, comment)
+	}
+}
+
+func (s *Server) EditCommentJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), "now", time.Now().Unix())
+	id, ok := commentIdJSON(w, r)
+	if !ok || !s.authorJSON(ctx, w, r, id) {
+		return
+	}
+
+	var req commentRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	} else if req.ParentId != nil {
+		writeJSONError(w, http.StatusBadRequest, "Replies cannot be moved")
+		return
+	}
+
+	if s.editWindow > 0 {
+		comment, err := s.db.GetCommentById(ctx, int(id))
+		if err != nil {
+			handleErrorJSON(ctx, w, err)
+			return
+		} else if time.Since(comment.Posted) > s.editWindow {
+			handleErrorJSON(ctx, w, errEditWindowPassed)
+			return
+		}
+	}
+
+	prepared, err := s.prepareComment(ctx, req.Content)
+	if err != nil {
+		handleErrorJSON(ctx, w, err)
+		return
+	}
+
+	if err := s.db.EditComment(ctx, id, prepared.Content, prepared.HTML, prepared.HeldReason); err != nil {
+		handleErrorJSON(ctx, w, err)
+		return
+	}
+
+	comment, err := s.db.GetCommentById(ctx, int(id))
+	if err != nil {
+		handleErrorJSON(ctx, w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, comment)
+}
+
+func (s *Server) DeleteCommentJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), "now", time.Now().Unix())
+	id, ok := commentIdJSON(w, r)
+	if !ok {
+		return
+	}
+
+	user := getUser(r)
+	if user == nil {
+		writeJSONError(w, http.StatusUnauthorized, "You need to be signed in")
+		return
+	}
+
+	if err := s.db.DeleteComment(ctx, id, user.Id); err != nil {
+		handleErrorJSON(ctx, w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Report a comment to the moderators
+func (s *Server) ReportCommentJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := commentIdJSON(w, r)
+	if !ok {
+		return
+	}
+
+	user := getUser(r)
+	if user == nil {
+		writeJSONError(w, http.StatusUnauthorized, "You need to be signed in")
+		return
+	}
+
+	var req reportRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeJSONError(w, http.StatusBadRequest, "Reports need a reason")
+		return
+	} else if len(req.Reason) > maxReportLen {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Reasons must be at most %d bytes", maxReportLen))
+		return
+	}
+
+	if err := s.db.ReportComment(ctx, id, user.Id, req.Reason); err != nil {
+		handleErrorJSON(ctx, w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,195 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpappel/penny/api"
+	"github.com/jpappel/penny/data"
+	"github.com/jpappel/penny/filters"
+)
+
+func requestJSON(s http.Handler, session *http.Cookie, method string, target string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Sec-Fetch-Site", "same-origin")
+	if session != nil {
+		r.AddCookie(session)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) (int, string) {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal("Invalid error envelope:", err)
+	}
+	return body.Error.Status, body.Error.Message
+}
+
+func TestCommentsJSON(t *testing.T) {
+	s, pdb := newTestServer(t)
+	if _, err := pdb.Db.Exec("INSERT INTO Users(email, provider, name) VALUES ('b@y.org', 'google', 'B Y')"); err != nil {
+		t.Fatal(err)
+	}
+	author := signIn(t, s, 1)
+	other := signIn(t, s, 2)
+
+	w := requestJSON(s, author, http.MethodPost, "/penny/api/v1/pages/open", `{"content": "cobbler"}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/penny/api/v1/comments/1" {
+		t.Fatalf("Failed to post comment: %d %s\n%s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	w = requestJSON(s, other, http.MethodPost, "/penny/api/v1/pages/open", `{"content": "with", "parentId": 1}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to post reply: %d\n%s", w.Code, w.Body)
+	}
+
+	w = requestJSON(s, nil, http.MethodGet, "/penny/api/v1/pages/open", "")
+	var page data.Page
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal("Invalid page:", err)
+	}
+	if page.Url != "open" || len(page.Comments) != 2 {
+		t.Fatalf("Unexpected page: %+v\n", page)
+	}
+	if c := page.Comments[0]; !strings.Contains(c.Rendered, "cobbler") || len(c.Replies) != 1 || c.Replies[0] != 2 {
+		t.Errorf("Unexpected root comment: %+v\n", c)
+	}
+	if c := page.Comments[1]; !strings.Contains(c.Rendered, "with") || c.Depth != 1 {
+		t.Errorf("Unexpected reply: %+v\n", c)
+	}
+
+	w = requestJSON(s, other, http.MethodPatch, "/penny/api/v1/comments/1", `{"content": "crumble"}`)
+	if status, _ := decodeError(t, w); w.Code != http.StatusForbidden || status != http.StatusForbidden {
+		t.Errorf("Expected non author edit to be forbidden, got %d\n", w.Code)
+	}
+
+	w = requestJSON(s, author, http.MethodPatch, "/penny/api/v1/comments/1", `{"content": "crumble"}`)
+	var comment data.Comment
+	if err := json.NewDecoder(w.Body).Decode(&comment); err != nil || !strings.Contains(comment.Rendered, "crumble") || comment.Edited == nil {
+		t.Errorf("Failed to edit comment: %d %+v %v\n", w.Code, comment, err)
+	}
+
+	w = requestJSON(s, other, http.MethodDelete, "/penny/api/v1/comments/1", "")
+	if status, message := decodeError(t, w); w.Code != http.StatusForbidden || status != http.StatusForbidden || message != data.ErrNotAuthor.Error() {
+		t.Errorf("Expected non author delete to be forbidden, got %d %q\n", w.Code, message)
+	}
+
+	w = requestJSON(s, author, http.MethodDelete, "/penny/api/v1/comments/1", "")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Failed to delete comment: %d\n%s", w.Code, w.Body)
+	}
+	backdateComments(t, pdb)
+
+	w = requestJSON(s, nil, http.MethodGet, "/penny/api/v1/comments/1", "")
+	if err := json.NewDecoder(w.Body).Decode(&comment); err != nil || !comment.Deleted || comment.Content != "" || comment.DeletedBy != data.DeletedByAuthor {
+		t.Errorf("Expected deleted comment, got %+v %v\n", comment, err)
+	}
+
+	// replies stay in the thread under the deleted comment
+	w = requestJSON(s, nil, http.MethodGet, "/penny/comments/open", "")
+	if body := w.Body.String(); !strings.Contains(body, "Deleted by author") || !strings.Contains(body, "with") {
+		t.Errorf("Expected deleted placeholder above the reply:\n%s", body)
+	}
+}
+
+func TestFilteredContentJSON(t *testing.T) {
+	renderer := filters.Renderer{Filters: []filters.Filterer{
+		filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "****"},
+		filters.RegexFilter{Patterns: []filters.RegexPattern{filters.RegexPresets["email"]}},
+	}}
+	s, _ := newTestServerConfig(t, api.ServerConfig{BaseUrl: "penny", SessionSecret: []byte("secret"), Renderer: renderer})
+	session := signIn(t, s, 1)
+
+	w := requestJSON(s, session, http.MethodPost, "/penny/api/v1/pages/open", `{"content": "moop, mail jp@jpappel.xyz"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to post comment: %d\n%s", w.Code, w.Body)
+	}
+
+	for _, path := range []string{"/penny/api/v1/pages/open", "/penny/api/v1/comments/1"} {
+		w = requestJSON(s, nil, http.MethodGet, path, "")
+		body := w.Body.String()
+		if strings.Contains(body, "moop") || strings.Contains(body, "jp@jpappel.xyz") {
+			t.Errorf("Expected filtered text to be left out of %s:\n%s", path, body)
+		} else if !strings.Contains(body, "[email]") {
+			t.Errorf("Expected redacted comment in %s:\n%s", path, body)
+		}
+	}
+}
+
+func TestEditWindow(t *testing.T) {
+	s, pdb := newTestServerConfig(t, api.ServerConfig{
+		BaseUrl:       "penny",
+		SessionSecret: []byte("secret"),
+		EditWindow:    time.Hour,
+	})
+	author := signIn(t, s, 1)
+
+	for _, content := range []string{"cobbler", "crumble"} {
+		if w := requestJSON(s, author, http.MethodPost, "/penny/api/v1/pages/open", `{"content": "`+content+`"}`); w.Code != http.StatusCreated {
+			t.Fatalf("Failed to post comment: %d\n%s", w.Code, w.Body)
+		}
+	}
+	if _, err := pdb.Db.Exec("UPDATE Comments SET postedTime = ? WHERE id = 2", time.Now().Add(-2*time.Hour).Unix()); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := requestJSON(s, author, http.MethodPatch, "/penny/api/v1/comments/1", `{"content": "pie"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected edit within the window to succeed, got %d\n%s", w.Code, w.Body)
+	}
+	w := requestJSON(s, author, http.MethodPatch, "/penny/api/v1/comments/2", `{"content": "pie"}`)
+	if _, message := decodeError(t, w); w.Code != http.StatusForbidden || message != "The time to edit this comment has passed" {
+		t.Errorf("Expected edit after the window to be forbidden, got %d %q", w.Code, message)
+	}
+}
+
+func TestErrorsJSON(t *testing.T) {
+	s, _ := newTestServer(t)
+	session := signIn(t, s, 1)
+
+	testCases := []struct {
+		name    string
+		session *http.Cookie
+		method  string
+		target  string
+		body    string
+		status  int
+	}{
+		{"MissingPage", nil, http.MethodGet, "/penny/api/v1/pages/I/do/not/exist", "", http.StatusNotFound},
+		{"MissingComment", nil, http.MethodGet, "/penny/api/v1/comments/100", "", http.StatusNotFound},
+		{"InvalidId", nil, http.MethodGet, "/penny/api/v1/comments/first", "", http.StatusBadRequest},
+		{"SignedOut", nil, http.MethodPost, "/penny/api/v1/pages/open", `{"content": "pie"}`, http.StatusUnauthorized},
+		{"ClosedPage", session, http.MethodPost, "/penny/api/v1/pages/closed", `{"content": "pie"}`, http.StatusForbidden},
+		{"MissingParent", session, http.MethodPost, "/penny/api/v1/pages/open", `{"content": "pie", "parentId": 7}`, http.StatusBadRequest},
+		{"EmptyComment", session, http.MethodPost, "/penny/api/v1/pages/open", `{"content": ""}`, http.StatusBadRequest},
+		{"UnknownField", session, http.MethodPost, "/penny/api/v1/pages/open", `{"text": "pie"}`, http.StatusBadRequest},
+		{"EditMissing", session, http.MethodPatch, "/penny/api/v1/comments/100", `{"content": "pie"}`, http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := requestJSON(s, tc.session, tc.method, tc.target, tc.body)
+			if w.Code != tc.status {
+				t.Errorf("Unexpected status: wanted %d got %d\n%s", tc.status, w.Code, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Unexpected content type: %s\n", ct)
+			}
+			if status, message := decodeError(t, w); status != tc.status || message == "" {
+				t.Errorf("Unexpected error envelope: %d `%s`\n", status, message)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark/extension"
+
+	"github.com/jpappel/penny/api"
+	"github.com/jpappel/penny/data"
+	"github.com/jpappel/penny/filters"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	s, pdb := newTestServerConfig(t, api.ServerConfig{BaseUrl: "penny", SessionSecret: []byte("secret"), Renderer: filters.Renderer{Markdown: filters.NewMarkdownConverter(extension.GFM)}})
+	session := signIn(t, s, 1)
+
+	content := `*apple* <script>alert(1)</script> [pie](javascript:alert(1))`
+	body, _ := json.Marshal(map[string]string{"content": content})
+	w := requestJSON(s, session, http.MethodPost, "/penny/api/v1/pages/open", string(body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to post comment: %d\n%s", w.Code, w.Body)
+	}
+
+	var comment data.Comment
+	if err := json.NewDecoder(w.Body).Decode(&comment); err != nil {
+		t.Fatal("Invalid comment:", err)
+	}
+	if comment.Content != "" {
+		t.Errorf("Expected raw content to be left out for readers, got %q", comment.Content)
+	}
+	var stored string
+	if err := pdb.Db.QueryRow("SELECT content FROM Comments WHERE id = ?", comment.Id).Scan(&stored); err != nil || stored != content {
+		t.Errorf("Expected raw content to be kept, got %q %v", stored, err)
+	}
+	if !strings.Contains(comment.Rendered, "<em>apple</em>") {
+		t.Errorf("Expected rendered markdown, got %q", comment.Rendered)
+	}
+	if strings.Contains(comment.Rendered, "<script") || strings.Contains(comment.Rendered, "javascript") {
+		t.Errorf("Expected rendered html to be sanitized, got %q", comment.Rendered)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/penny/comments/open", nil))
+	if html := w.Body.String(); !strings.Contains(html, "<em>apple</em>") || strings.Contains(html, "<script") {
+		t.Errorf("Expected rendered comment on page:\n%s", html)
+	}
+}
+
+func TestPlainComments(t *testing.T) {
+	s, _ := newTestServer(t)
+	session := signIn(t, s, 1)
+
+	w := requestJSON(s, session, http.MethodPost, "/penny/api/v1/pages/open", `{"content": "*apple* <b>pie</b>"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to post comment: %d\n%s", w.Code, w.Body)
+	}
+	var comment data.Comment
+	if err := json.NewDecoder(w.Body).Decode(&comment); err != nil {
+		t.Fatal("Invalid comment:", err)
+	}
+	if comment.Rendered != "<p>*apple* &lt;b&gt;pie&lt;/b&gt;</p>\n" {
+		t.Errorf("Expected escaped plain text html, got %q", comment.Rendered)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/penny/comments/open", nil))
+	if html := w.Body.String(); !strings.Contains(html, "*apple* &lt;b&gt;pie&lt;/b&gt;") {
+		t.Errorf("Expected escaped plain text comment:\n%s", html)
+	}
+}
+
+func TestFilterVerdicts(t *testing.T) {
+	renderer := filters.Renderer{Filters: []filters.Filterer{
+		filters.WordFilter{Words: map[string]bool{"spam": true}, Action: filters.HoldWords},
+		filters.WordFilter{Words: map[string]bool{"apple": true}, Action: filters.RejectWords},
+	}}
+	s, pdb := newTestServerConfig(t, api.ServerConfig{BaseUrl: "penny", SessionSecret: []byte("secret"), Renderer: renderer})
+	session := signIn(t, s, 1)
+
+	w := requestJSON(s, session, http.MethodPost, "/penny/api/v1/pages/open", `{"content": "buy spam"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected comment to be held: %d\n%s", w.Code, w.Body)
+	}
+	var comment data.Comment
+	if err := json.NewDecoder(w.Body).Decode(&comment); err != nil {
+		t.Fatal("Invalid comment:", err)
+	}
+	if !comment.Held || comment.Content != "" {
+		t.Errorf("Expected a held comment without content, got %+v", comment)
+	}
+	backdateComments(t, pdb)
+	w = requestJSON(s, nil, http.MethodGet, "/penny/api/v1/comments/1", "")
+	if err := json.NewDecoder(w.Body).Decode(&comment); err != nil || !comment.Held || !comment.Hidden || comment.Content != "" {
+		t.Errorf("Expected a hidden held comment without content, got %+v %v", comment, err)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/penny/comments/open", nil))
+	if html := w.Body.String(); !strings.Contains(html, "Awaiting moderation") || strings.Contains(html, "buy spam") {
+		t.Errorf("Expected held comment to be withheld from the page:\n%s", html)
+	}
+
+	w = requestJSON(s, session, http.MethodPost, "/penny/api/v1/pages/open", `{"content": "an apple"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected comment to be rejected: %d\n%s", w.Code, w.Body)
+	}
+	if _, message := decodeError(t, w); message != "Comment rejected: "+filters.ErrBannedWord.Error() {
+		t.Errorf("Expected rejection reason, got %q", message)
+	}
+}
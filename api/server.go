@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jpappel/penny/auth"
+	"github.com/jpappel/penny/data"
+	"github.com/jpappel/penny/filters"
+)
+
+type ServerConfig struct {
+	BaseUrl string
+	// filters and renders comments when they are posted
+	Renderer  filters.Renderer
+	Providers []*auth.OAuthProvider
+	// key used to sign cookies, a random key is used if empty
+	SessionSecret   []byte
+	SessionLifetime time.Duration
+	// how often session tokens are replaced
+	SessionRotate time.Duration
+	// only send cookies over https
+	SecureCookies bool
+	// origins other than penny's own allowed to make JSON requests
+	TrustedOrigins []string
+	// emails of users made administrators when the server starts or they sign in, by provider
+	Admins map[string][]string
+	// how long after posting authors may edit a comment, forever when 0
+	EditWindow time.Duration
+}
+
+// Penny's http server, sharing a single database between all handlers
+type Server struct {
+	db        data.PennyDB
+	base      string
+	renderer  filters.Renderer
+	providers map[string]*auth.OAuthProvider
+	// providers in the order they are shown to users
+	providerOrder   []*auth.OAuthProvider
+	signer          auth.Signer
+	sessionLifetime time.Duration
+	sessionRotate   time.Duration
+	secureCookies   bool
+	trustedOrigins  []string
+	admins          map[string]bool
+	editWindow      time.Duration
+	handler         http.Handler
+}
+
+// Create a server which takes ownership of pdb
+func NewServer(pdb data.PennyDB, cfg ServerConfig) *Server {
+	s := &Server{
+		db:              pdb,
+		renderer:        cfg.Renderer,
+		providers:       make(map[string]*auth.OAuthProvider, len(cfg.Providers)),
+		providerOrder:   cfg.Providers,
+		sessionLifetime: cfg.SessionLifetime,
+		sessionRotate:   cfg.SessionRotate,
+		secureCookies:   cfg.SecureCookies,
+		trustedOrigins:  cfg.TrustedOrigins,
+		editWindow:      cfg.EditWindow,
+	}
+	for _, p := range cfg.Providers {
+		s.providers[p.Key] = p
+	}
+	s.admins = make(map[string]bool)
+	for provider, emails := range cfg.Admins {
+		for _, email := range emails {
+			s.admins[adminKey(provider, email)] = true
+		}
+	}
+	if granted, err := pdb.GrantAdmins(context.Background(), cfg.Admins); err != nil {
+		slog.Error("Failed to grant configured admins", slog.Any("error", err))
+	} else if granted > 0 {
+		slog.Info("Granted configured admins", slog.Int64("users", granted))
+	}
+	if cfg.BaseUrl != "" {
+		s.base = fmt.Sprint("/", cfg.BaseUrl)
+	}
+
+	secret := cfg.SessionSecret
+	if len(secret) == 0 {
+		slog.Warn("No session secret configured, sessions will not persist across restarts")
+		secret = []byte(randomToken(32))
+	}
+	s.signer = auth.NewSigner(secret)
+
+	if s.sessionLifetime <= 0 {
+		s.sessionLifetime = 30 * 24 * time.Hour
+	}
+	if s.sessionRotate <= 0 {
+		s.sessionRotate = 24 * time.Hour
+	}
+
+	logger := slog.Default()
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprint("/", cfg.BaseUrl), s.ListPages)
+	mux.HandleFunc(fmt.Sprint(s.base, "/comments/{pageUrl...}"), s.GetComments)
+	mux.Handle(fmt.Sprintf("GET %s/new/comments/{pageUrl...}", s.base), Log(http.HandlerFunc(s.NewComment), logger))
+	mux.Handle(fmt.Sprintf("POST %s/new/comments/{pageUrl...}", s.base), Log(s.notBanned(http.HandlerFunc(s.PostComment), false), logger))
+	mux.Handle(fmt.Sprintf("GET %s/auth/{provider}/login", s.base), Log(http.HandlerFunc(s.Login), logger))
+	mux.Handle(fmt.Sprintf("GET %s/auth/{provider}/callback", s.base), Log(http.HandlerFunc(s.Callback), logger))
+	mux.Handle(fmt.Sprintf("POST %s/auth/logout", s.base), Log(http.HandlerFunc(s.SignOut), logger))
+
+	v1 := fmt.Sprint(s.base, "/api/v1")
+	mux.Handle(fmt.Sprintf("GET %s/pages", v1), Log(http.HandlerFunc(s.ListPagesJSON), logger))
+	mux.Handle(fmt.Sprintf("GET %s/pages/{pageUrl...}", v1), Log(http.HandlerFunc(s.GetPageJSON), logger))
+	mux.Handle(fmt.Sprintf("POST %s/pages/{pageUrl...}", v1), Log(s.notBanned(http.HandlerFunc(s.PostCommentJSON), true), logger))
+	mux.Handle(fmt.Sprintf("GET %s/comments/{id}", v1), Log(http.HandlerFunc(s.GetCommentJSON), logger))
+	mux.Handle(fmt.Sprintf("PATCH %s/comments/{id}", v1), Log(s.notBanned(http.HandlerFunc(s.EditCommentJSON), true), logger))
+	mux.Handle(fmt.Sprintf("DELETE %s/comments/{id}", v1), Log(http.HandlerFunc(s.DeleteCommentJSON), logger))
+	mux.Handle(fmt.Sprintf("POST %s/comments/{id}/report", v1), Log(s.notBanned(http.HandlerFunc(s.ReportCommentJSON), true), logger))
+
+	moderator := func(h http.HandlerFunc) http.Handler { return Log(s.requireModerator(h), logger) }
+	admin := func(h http.HandlerFunc) http.Handler { return Log(s.requireAdmin(h), logger) }
+	mux.Handle(fmt.Sprintf("GET %s/admin", s.base), moderator(s.AdminDashboard))
+	mux.Handle(fmt.Sprintf("POST %s/admin/comments", s.base), moderator(s.ModerateComments))
+	mux.Handle(fmt.Sprintf("GET %s/admin/comments/{id}/history", s.base), moderator(s.CommentHistory))
+	mux.Handle(fmt.Sprintf("GET %s/admin/pages", s.base), moderator(s.AdminPages))
+	mux.Handle(fmt.Sprintf("POST %s/admin/pages", s.base), moderator(s.ManagePage))
+	mux.Handle(fmt.Sprintf("GET %s/admin/users", s.base), admin(s.AdminUsers))
+	mux.Handle(fmt.Sprintf("POST %s/admin/users", s.base), admin(s.SetRole))
+	mux.Handle(fmt.Sprintf("GET %s/admin/audit", s.base), admin(s.AuditLog))
+
+	mux.Handle(fmt.Sprintf("GET %s/embed.js", s.base), Log(http.HandlerFunc(s.EmbedScript), logger))
+
+	s.handler = CORS(s.Sessions(s.CSRF(mux)), s.trustedOrigins)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Release the server's database
+func (s *Server) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jpappel/penny/auth"
+	"github.com/jpappel/penny/data"
+)
+
+const sessionCookie = "penny_session"
+
+// How long a session's token is still accepted after it is rotated
+const sessionRotateGrace = 30 * time.Second
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	sessionKey
+	roleKey
+	permissionsKey
+)
+
+// Get the signed in user making a request, nil if they are not signed in
+func getUser(r *http.Request) *auth.User {
+	user, _ := r.Context().Value(userKey).(*auth.User)
+	return user
+}
+
+// Get the role of the signed in user making a request, empty if they are not signed in
+func getRole(r *http.Request) data.Role {
+	role, _ := r.Context().Value(roleKey).(data.Role)
+	return role
+}
+
+func (s *Server) cookiePath() string {
+	return fmt.Sprint(s.base, "/")
+}
+
+// Session cookies must be sent cross site for the embed widget to sign in
+func (s *Server) sameSite() http.SameSite {
+	if s.secureCookies && len(s.trustedOrigins) > 0 {
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+func (s *Server) setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.signer.Sign(token),
+		Path:     s.cookiePath(),
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: s.sameSite(),
+	})
+}
+
+func (s *Server) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     s.cookiePath(),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: s.sameSite(),
+	})
+}
+
+// Get the session token from a request's cookie if it has a valid signature
+func (s *Server) sessionToken(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	return s.signer.Verify(cookie.Value)
+}
+
+// Start a new session for a user, replacing any existing session
+func (s *Server) SignIn(w http.ResponseWriter, r *http.Request, userId int64) error {
+	ctx := r.Context()
+	if token, ok := s.sessionToken(r); ok {
+		if err := s.db.DeleteSession(ctx, auth.HashToken(token)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.db.DeleteExpiredSessions(ctx, time.Now()); err != nil {
+		slog.WarnContext(ctx, "Failed to remove expired sessions", slog.Any("error", err))
+	}
+
+	token := randomToken(32)
+	expires := time.Now().Add(s.sessionLifetime)
+	if err := s.db.CreateSession(ctx, auth.HashToken(token), userId, expires); err != nil {
+		return err
+	}
+
+	s.setSessionCookie(w, token, expires)
+	return nil
+}
+
+// End the current session
+func (s *Server) SignOut(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "<h1>Error 400</h1><p>Malformed form data</p>")
+		return
+	}
+
+	if token, ok := s.sessionToken(r); ok {
+		if err := s.db.DeleteSession(ctx, auth.HashToken(token)); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete session", slog.Any("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, "<h1>Internal Server Error</h1>")
+			return
+		}
+	}
+	s.clearSessionCookie(w)
+
+	dest := s.safeReturn(r.Form.Get("return"))
+	if dest == "" {
+		dest = s.cookiePath()
+	}
+	http.Redirect(w, r, dest, http.StatusSeeOther)
+}
+
+// Middleware placing the signed in user into the request context
+//
+// Sessions older than the rotation interval are given a new token.
+func (s *Server) Sessions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := s.sessionToken(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		ctx := context.WithValue(r.Context(), "now", now.Unix())
+		hash := auth.HashToken(token)
+		session, err := s.db.GetSession(ctx, hash)
+		if err == data.ErrNoSession {
+			s.clearSessionCookie(w)
+			next.ServeHTTP(w, r)
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Failed to get session", slog.Any("error", err))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if now.Sub(session.Rotated) > s.sessionRotate {
+			newToken := randomToken(32)
+			// ErrNoSession means a request sent in parallel already rotated the token
+			err := s.db.RotateSession(ctx, hash, auth.HashToken(newToken), now.Add(sessionRotateGrace))
+			if err == nil {
+				s.setSessionCookie(w, newToken, session.Expires)
+			} else if err != data.ErrNoSession {
+				slog.ErrorContext(ctx, "Failed to rotate session", slog.Any("error", err))
+			}
+		}
+
+		user := &auth.User{
+			Id:       session.User.Id,
+			Name:     session.User.Name,
+			Email:    session.User.Email,
+			Provider: session.User.Provider,
+		}
+		ctx = context.WithValue(r.Context(), userKey, user)
+		ctx = context.WithValue(ctx, sessionKey, session.Id)
+		ctx = context.WithValue(ctx, roleKey, session.User.Role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
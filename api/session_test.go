@@ -0,0 +1,104 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpappel/penny/api"
+)
+
+func getNewComment(s *api.Server, session *http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/penny/new/comments/open", nil)
+	if session != nil {
+		r.AddCookie(session)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestSession(t *testing.T) {
+	s, _ := newTestServer(t)
+	session := signIn(t, s, 1)
+
+	if !session.HttpOnly || session.SameSite != http.SameSiteLaxMode {
+		t.Errorf("Session cookie is not HttpOnly and SameSite: %v\n", session)
+	}
+
+	if w := getNewComment(s, session); !strings.Contains(w.Body.String(), "Posting As JP Appel") {
+		t.Errorf("Expected signed in form, got:\n%s", w.Body)
+	}
+
+	forged := *session
+	forged.Value = strings.Replace(session.Value, ".", "x.", 1)
+	if w := getNewComment(s, &forged); !strings.Contains(w.Body.String(), "You need to be signed in") {
+		t.Errorf("Expected forged session to be rejected, got:\n%s", w.Body)
+	}
+
+	form := url.Values{"return": {"/penny/new/comments/open"}, "csrf_token": {csrfToken(t, s, session)}}
+	r := httptest.NewRequest(http.MethodPost, "/penny/auth/logout", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(session)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/penny/new/comments/open" {
+		t.Errorf("Unexpected sign out response: %d %s\n", w.Code, w.Header().Get("Location"))
+	}
+
+	if w := getNewComment(s, session); !strings.Contains(w.Body.String(), "You need to be signed in") {
+		t.Errorf("Expected session to end after signing out, got:\n%s", w.Body)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/penny/auth/logout", strings.NewReader("return=%zz"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected malformed sign out form to fail, got %d\n", w.Code)
+	}
+}
+
+func TestSessionRotation(t *testing.T) {
+	s, pdb := newTestServerConfig(t, api.ServerConfig{
+		BaseUrl:       "penny",
+		SessionSecret: []byte("secret"),
+		SessionRotate: time.Nanosecond,
+	})
+	session := signIn(t, s, 1)
+	time.Sleep(time.Millisecond)
+
+	w := getNewComment(s, session)
+	if !strings.Contains(w.Body.String(), "Posting As JP Appel") {
+		t.Fatalf("Expected signed in form, got:\n%s", w.Body)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value == session.Value {
+		t.Fatalf("Expected a rotated session cookie, got %v\n", cookies)
+	}
+	rotated := cookies[0]
+
+	// a request sent alongside the first still carries the old token
+	w = getNewComment(s, session)
+	if !strings.Contains(w.Body.String(), "Posting As JP Appel") {
+		t.Errorf("Expected old session token to be accepted right after rotation, got:\n%s", w.Body)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("Expected the rotated session to be left alone, got %v\n", cookies)
+	}
+
+	if _, err := pdb.Db.Exec("UPDATE Sessions SET previousExpiresTime = 0"); err != nil {
+		t.Fatal(err)
+	}
+	if w := getNewComment(s, session); !strings.Contains(w.Body.String(), "You need to be signed in") {
+		t.Error("Expected old session token to be invalid once its grace period ended")
+	}
+
+	if w := getNewComment(s, rotated); !strings.Contains(w.Body.String(), "Posting As JP Appel") {
+		t.Errorf("Expected rotated session token to be accepted, got:\n%s", w.Body)
+	}
+}
@@ -0,0 +1,249 @@
+// Penny comment widget
+//
+// Usage:
+//   <div id="penny"></div>
+//   <script src="https://comments.example.com/penny/embed.js" async></script>
+//
+// The page comments are attached to defaults to the current path and can be
+// set with a data-penny-page attribute on the container.
+(function () {
+    "use strict";
+
+    const script = document.currentScript;
+    const penny = script.src.slice(0, script.src.lastIndexOf("/"));
+
+    function findContainer() {
+        return document.querySelector("[data-penny-page]") || document.getElementById("penny");
+    }
+
+    function pageUrl(container) {
+        const page = container.dataset.pennyPage || window.location.pathname;
+        return page.replace(/^\/+|\/+$/g, "");
+    }
+
+    function element(tag, className, text) {
+        const el = document.createElement(tag);
+        if (className) {
+            el.className = className;
+        }
+        if (text !== undefined) {
+            el.textContent = text;
+        }
+        return el;
+    }
+
+    function renderComment(comment, byId, onReply) {
+        const root = element("div", "pennyComment");
+        root.id = "pennyComment_" + comment.id;
+
+        const header = element("h3");
+        const link = element("a", "", "# " + comment.id);
+        link.href = "#" + root.id;
+        header.appendChild(link);
+        root.appendChild(header);
+
+        const posted = new Date(comment.posted);
+        const time = element("time", "", posted.toLocaleString());
+        time.dateTime = comment.posted;
+        root.appendChild(time);
+
+        if (comment.edited) {
+            const edited = element("span", "pennyEdited", "Edited ");
+            const editedTime = element("time", "", new Date(comment.edited).toLocaleString());
+            editedTime.dateTime = comment.edited;
+            edited.appendChild(editedTime);
+            root.appendChild(edited);
+        }
+
+        const content = element("div", "pennyContent");
+        // html is rendered and sanitized by penny, plain content is untrusted text
+        const body = element("div");
+        if (comment.html) {
+            body.innerHTML = comment.html;
+        } else {
+            body.appendChild(element("p", "", comment.content));
+        }
+        if (comment.deleted) {
+            const deleted = { author: "Deleted by author", moderator: "Removed by moderator" }[comment.deletedBy] || "Deleted";
+            content.appendChild(element("p")).appendChild(element("i", "", deleted));
+            if (comment.reason) {
+                content.appendChild(element("p", "pennyReason", "Reason: " + comment.reason));
+            }
+        } else if (comment.held) {
+            content.appendChild(element("p")).appendChild(element("i", "", "Awaiting moderation"));
+        } else if (comment.hidden) {
+            const details = element("details");
+            details.appendChild(element("summary", "", comment.reason ? "Hidden: " + comment.reason : "Hidden"));
+            details.append(...body.childNodes);
+            content.appendChild(details);
+        } else {
+            content.append(...body.childNodes);
+        }
+        root.appendChild(content);
+
+        if (!comment.deleted) {
+            const reply = element("button", "pennyReplyButton", "Reply");
+            reply.type = "button";
+            reply.addEventListener("click", () => onReply(comment.id));
+            root.appendChild(reply);
+        }
+
+        const replies = comment.replies || [];
+        if (replies.length > 0) {
+            const list = element("div", "pennyReplies");
+            for (const id of replies) {
+                if (byId.has(id)) {
+                    list.appendChild(renderComment(byId.get(id), byId, onReply));
+                }
+            }
+            root.appendChild(list);
+        }
+
+        return root;
+    }
+
+    async function loadComments(widget) {
+        const resp = await fetch(`${penny}/api/v1/pages/${encodeURI(widget.page)}`, { credentials: "include" });
+        widget.comments.replaceChildren();
+
+        if (resp.status === 404) {
+            widget.comments.appendChild(element("p", "pennyEmpty", "No comments yet"));
+            return;
+        } else if (!resp.ok) {
+            widget.comments.appendChild(element("p", "pennyError", "Unable to load comments"));
+            return;
+        }
+
+        const page = await resp.json();
+        const byId = new Map(page.comments.map((c) => [c.id, c]));
+        for (const comment of page.comments) {
+            if (comment.depth === 0) {
+                widget.comments.appendChild(renderComment(comment, byId, (id) => widget.replyTo(id)));
+            }
+        }
+        typeset(widget.comments);
+    }
+
+    // Draw math and diagrams when the host page loads KaTeX's auto-render or mermaid
+    function typeset(root) {
+        if (window.renderMathInElement) {
+            for (const math of root.querySelectorAll(".penny-math")) {
+                window.renderMathInElement(math);
+            }
+        }
+        if (window.mermaid) {
+            window.mermaid.run({ nodes: root.querySelectorAll("pre.mermaid") });
+        }
+    }
+
+    async function loadForm(widget) {
+        const resp = await fetch(`${penny}/new/comments/${encodeURI(widget.page)}`, { credentials: "include" });
+        if (!resp.ok) {
+            widget.form.replaceChildren(element("p", "pennyError", "Unable to load comment form"));
+            return;
+        }
+        widget.form.innerHTML = await resp.text();
+
+        // return to this page after signing in
+        for (const link of widget.form.querySelectorAll("a[href*='/auth/']")) {
+            const url = new URL(link.getAttribute("href"), penny);
+            url.searchParams.set("return", window.location.href);
+            link.href = url.toString();
+        }
+
+        const post = widget.form.querySelector("form[name=postComment]");
+        if (post) {
+            post.addEventListener("submit", (event) => {
+                event.preventDefault();
+                postComment(widget, post);
+            });
+        }
+
+        const signOut = widget.form.querySelector("form[name=signOut]");
+        if (signOut) {
+            signOut.addEventListener("submit", async (event) => {
+                event.preventDefault();
+                await fetch(new URL(signOut.getAttribute("action"), penny), {
+                    method: "POST",
+                    credentials: "include",
+                    redirect: "manual",
+                    body: new URLSearchParams(new FormData(signOut)),
+                });
+                loadForm(widget);
+            });
+        }
+    }
+
+    async function postComment(widget, form) {
+        const error = form.querySelector(".pennyError") || element("p", "pennyError");
+        error.remove();
+        form.querySelector(".pennyNotice")?.remove();
+
+        const body = { content: form.elements.commentText.value };
+        const parentId = form.elements.parentId.value;
+        if (parentId !== "") {
+            body.parentId = Number(parentId);
+        }
+
+        const resp = await fetch(`${penny}/api/v1/pages/${encodeURI(widget.page)}`, {
+            method: "POST",
+            credentials: "include",
+            headers: {
+                "Content-Type": "application/json",
+                "X-CSRF-Token": form.elements.csrf_token.value,
+            },
+            body: JSON.stringify(body),
+        });
+
+        if (!resp.ok) {
+            const message = await resp.json().then((e) => e.error.message, () => resp.statusText);
+            error.textContent = message;
+            form.prepend(error);
+            return;
+        }
+
+        const comment = await resp.json();
+        form.reset();
+        if (resp.status === 202) {
+            form.prepend(element("p", "pennyNotice", "Your comment will appear once a moderator approves it"));
+        }
+        await loadComments(widget);
+        const posted = document.getElementById("pennyComment_" + comment.id);
+        if (posted) {
+            posted.scrollIntoView();
+        }
+    }
+
+    function mount(container) {
+        const widget = {
+            page: pageUrl(container),
+            comments: element("div", "pennyComments"),
+            form: element("div", "pennyNewComment"),
+            replyTo(id) {
+                const input = this.form.querySelector("#pennyReplyTo");
+                const text = this.form.querySelector("#pennyCommentText");
+                if (input && text) {
+                    input.value = id;
+                    text.focus();
+                }
+            },
+        };
+
+        container.replaceChildren(widget.comments, widget.form);
+        loadComments(widget);
+        loadForm(widget);
+    }
+
+    function init() {
+        const container = findContainer();
+        if (container) {
+            mount(container);
+        }
+    }
+
+    if (document.readyState === "loading") {
+        document.addEventListener("DOMContentLoaded", init);
+    } else {
+        init();
+    }
+})();
@@ -11,5 +11,9 @@ var tmplFS embed.FS
 var tmpls *template.Template
 
 func init() {
-	tmpls = template.Must(template.ParseFS(tmplFS, "templates/*.html"))
+	funcs := template.FuncMap{
+		// rendered comments are sanitized before they reach a template
+		"trusted": func(s string) template.HTML { return template.HTML(s) },
+	}
+	tmpls = template.Must(template.New("").Funcs(funcs).ParseFS(tmplFS, "templates/*.html"))
 }
@@ -2,33 +2,53 @@ package auth
 
 import (
 	"context"
-	"fmt"
-	"log/slog"
+	"net/http"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/github"
 )
 
-func register(ctx context.Context) {
-	conf := &oauth2.Config{
-		ClientID:     "",
-		ClientSecret: "",
-		Scopes:       []string{},
-		Endpoint:     github.Endpoint,
+func newGitHub() *OAuthProvider {
+	return &OAuthProvider{
+		Key:  "github",
+		Name: "GitHub",
+		Config: oauth2.Config{
+			Scopes:   []string{"read:user", "user:email"},
+			Endpoint: github.Endpoint,
+		},
+		profile: githubProfile,
 	}
+}
 
-	verifier := oauth2.GenerateVerifier()
-	url := conf.AuthCodeURL("", oauth2.S256ChallengeOption(verifier))
+func githubProfile(ctx context.Context, client *http.Client) (User, error) {
+	var profile struct {
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", &profile); err != nil {
+		return User{}, err
+	}
 
-	// TODO: get auth code
-	var code string
+	// the public profile email may be hidden or unverified
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", &emails); err != nil {
+		return User{}, err
+	}
 
-	tok, err := conf.Exchange(ctx, code, oauth2.VerifierOption(verifier))
-	if err != nil {
-		slog.ErrorContext(ctx, "Error occured while getting oauth2 token", slog.Any("error", err))
-		panic(err)
+	user := User{Name: profile.Name}
+	if user.Name == "" {
+		user.Name = profile.Login
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			user.Email = e.Email
+			break
+		}
 	}
 
-	client := conf.Client(ctx, tok)
-	fmt.Println(url, client)
+	return user, nil
 }
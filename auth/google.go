@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+func newGoogle() *OAuthProvider {
+	return &OAuthProvider{
+		Key:  "google",
+		Name: "Google",
+		Config: oauth2.Config{
+			Scopes:   []string{"openid", "email", "profile"},
+			Endpoint: endpoints.Google,
+		},
+		profile: googleProfile,
+	}
+}
+
+func googleProfile(ctx context.Context, client *http.Client) (User, error) {
+	var profile struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	err := getJSON(ctx, client, "https://openidconnect.googleapis.com/v1/userinfo", &profile)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{Name: profile.Name}
+	if profile.EmailVerified {
+		user.Email = profile.Email
+	}
+
+	return user, nil
+}
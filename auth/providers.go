@@ -1,11 +1,99 @@
 package auth
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
 type User struct {
-	Name  string
-	Email string
+	Id       int64
+	Name     string
+	Email    string
+	Provider string
 }
 
+// A sign in option shown to users
 type Provider struct {
 	Url  string
 	Name string
 }
+
+// An OAuth2 identity provider users can sign in with
+type OAuthProvider struct {
+	Key    string // lowercase identifier used in urls and the Users table
+	Name   string
+	Config oauth2.Config
+	// fetch the profile of the user who authorized client
+	profile func(ctx context.Context, client *http.Client) (User, error)
+}
+
+var ErrUnknownProvider error = errors.New("Unknown OAuth provider")
+var ErrNoEmail error = errors.New("Provider did not return a verified email")
+
+// Names of the providers penny knows how to sign in with
+var SupportedProviders = []string{"GitHub", "Google"}
+
+// Create a provider by name, redirecting back to callbackUrl after authorization
+func NewOAuthProvider(name string, clientId string, clientSecret string, callbackUrl string) (*OAuthProvider, error) {
+	var p *OAuthProvider
+	switch strings.ToLower(name) {
+	case "github":
+		p = newGitHub()
+	case "google":
+		p = newGoogle()
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+
+	p.Config.ClientID = clientId
+	p.Config.ClientSecret = clientSecret
+	p.Config.RedirectURL = callbackUrl
+
+	return p, nil
+}
+
+// Exchange an authorization code for the profile of the user who granted it
+func (p *OAuthProvider) Authenticate(ctx context.Context, code string, verifier string) (User, error) {
+	tok, err := p.Config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return User{}, err
+	}
+
+	user, err := p.profile(ctx, p.Config.Client(ctx, tok))
+	if err != nil {
+		return User{}, err
+	}
+	if user.Email == "" {
+		return User{}, ErrNoEmail
+	}
+	user.Provider = p.Key
+
+	return user, nil
+}
+
+// Decode a json response from a provider's api
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status from %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// Signs and verifies values stored in cookies
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) Signer {
+	return Signer{key}
+}
+
+func (s Signer) mac(value string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(value))
+	return h.Sum(nil)
+}
+
+// Append a signature to a value, value must not contain '.'
+func (s Signer) Sign(value string) string {
+	return value + "." + base64.RawURLEncoding.EncodeToString(s.mac(value))
+}
+
+// Derive a url safe token from a value, for tokens that must be reproducible
+func (s Signer) Token(value string) string {
+	return base64.RawURLEncoding.EncodeToString(s.mac(value))
+}
+
+// Get the value from a signed string, reporting if the signature is valid
+func (s Signer) Verify(signed string) (string, bool) {
+	value, sig, ok := strings.Cut(signed, ".")
+	if !ok {
+		return "", false
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(buf, s.mac(value)) {
+		return "", false
+	}
+
+	return value, true
+}
+
+// Hash a secret token for storage
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jpappel/penny/data"
+	"github.com/jpappel/penny/filters"
+)
+
+type SessionConfig struct {
+	Lifetime int `json:"lifetime"` // seconds
+	Rotate   int `json:"rotate"`   // seconds
+}
+
+type MarkdownConfig struct {
+	// names of the markdown extensions to enable, see filters.MarkdownExtensions
+	Extensions []string `json:"extensions"`
+	// tag rendered nodes with penny classes and demote headings
+	PennyClasses bool `json:"penny_classes"`
+	// class names replacing filters.DefaultClassNames, empty names keep the default
+	ClassNames filters.ClassNames `json:"class_names"`
+}
+
+// A word filter reading its words from a file
+type WordFilterConfig struct {
+	File        string `json:"file"`
+	Replacement string `json:"replacement"`
+	// replace matched words, reject the comment or hold it for moderation, defaults to replace
+	Mode           string `json:"mode"`
+	Substring      bool   `json:"substring"`
+	Leetspeak      bool   `json:"leetspeak"`
+	PreserveLength bool   `json:"preserve_length"`
+	// match multi word phrases, suited to large lists
+	Phrases bool `json:"phrases"`
+}
+
+// A regex filter replacing matches of a pattern
+type RegexFilterConfig struct {
+	Pattern string `json:"pattern"`
+	// template for the replacement, $1 or ${name} insert submatches
+	Replacement string `json:"replacement"`
+}
+
+type Config struct {
+	Host           string                       `json:"hostname"`
+	Port           int                          `json:"port"`
+	BaseUrl        string                       `json:"base_url"`
+	RenderMD       bool                         `json:"render_markdown"`
+	Markdown       MarkdownConfig               `json:"markdown"`
+	Providers      []string                     `json:"providers"`
+	EnvFilename    string                       `json:"env_file"`
+	EnabledFilters []string                     `json:"filters"`
+	WordFilters    map[string]WordFilterConfig  `json:"word_filters"`
+	RegexFilters   map[string]RegexFilterConfig `json:"regex_filters"`
+	DbFile         string                       `json:"database"`
+	DbPool         data.PoolConfig              `json:"database_pool"`
+	PublicUrl      string                       `json:"public_url"`
+	TrustedOrigins []string                     `json:"trusted_origins"`
+	// emails of users made administrators, by provider
+	Admins        map[string][]string `json:"admins"`
+	Session       SessionConfig       `json:"session"`
+	SessionSecret []byte              `json:"-"`
+	// how long deleted comments keep their content before it is purged, in seconds
+	PurgeAfter int `json:"purge_after"`
+	// how long after posting authors may edit a comment in seconds, forever when 0
+	EditWindow int `json:"edit_window"`
+}
+
+const SystemConfigFile = "/etc/penny/config.json"
+const defaultEnvFile = ".env"
+
+// The built in configuration every other source is layered over
+func Default() Config {
+	return Config{
+		Port:        8080,
+		EnvFilename: defaultEnvFile,
+		DbFile:      "file:data.sqlite3",
+		PurgeAfter:  30 * 24 * 60 * 60,
+		Markdown: MarkdownConfig{
+			Extensions:   slices.Clone(filters.DefaultMarkdownExtensions),
+			PennyClasses: true,
+		},
+	}
+}
+
+// Paths of the config files read by default, in increasing precedence
+func DefaultFiles() []string {
+	files := []string{SystemConfigFile}
+	if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, filepath.Join(dir, "penny", "config.json"))
+	}
+	return files
+}
+
+// Load the configuration from every source, returning any arguments left after flags
+//
+// Sources in increasing precedence:
+//   - built in defaults
+//   - /etc/penny/config.json
+//   - $XDG_CONFIG_HOME/penny/config.json
+//   - the file given by -config
+//   - PENNY_* environment variables
+//   - command line flags
+//
+// All problems with the configuration are reported together.
+func Load(args []string) (Config, []string, error) {
+	cfg := Default()
+	flagSet, flags := newFlagSet(&cfg)
+	configFile := flagSet.String("config", "", "path to a config file")
+	if err := flagSet.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	var errs []error
+	for _, filename := range DefaultFiles() {
+		if err := readFile(filename, &cfg); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	if *configFile != "" {
+		if err := readFile(*configFile, &cfg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// the env file may itself set PENNY_* variables so overrides are applied again after reading it,
+	// only that pass reports invalid values so each is reported once
+	applyEnv(&cfg)
+	flags.apply(flagSet)
+	if err := readEnvfile(cfg.EnvFilename); err != nil {
+		if cfg.EnvFilename != defaultEnvFile || !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		} else {
+			slog.Warn("Unable to open environment file", slog.String("filename", cfg.EnvFilename))
+		}
+	}
+	errs = append(errs, applyEnv(&cfg)...)
+	flags.apply(flagSet)
+
+	if secret := strings.TrimSpace(os.Getenv("PENNY_SESSION_SECRET")); secret != "" {
+		cfg.SessionSecret = []byte(secret)
+	}
+
+	cfg.BaseUrl = strings.Trim(cfg.BaseUrl, "/")
+	if cfg.PublicUrl == "" {
+		host := cfg.Host
+		if host == "" {
+			host = "localhost"
+		}
+		cfg.PublicUrl = fmt.Sprintf("http://%s:%d", host, cfg.Port)
+	}
+	cfg.PublicUrl = strings.TrimSuffix(cfg.PublicUrl, "/")
+
+	errs = append(errs, cfg.Validate())
+	return cfg, flagSet.Args(), errors.Join(errs...)
+}
+
+// Overlay the values in a json config file
+func readFile(filename string, cfg *Config) error {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return nil
+}
+
+// Set env vars to values in a file
+func readEnvfile(filename string) error {
+	envFile, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer envFile.Close()
+
+	buf, err := io.ReadAll(envFile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if len(buf) > 2<<16 {
+		slog.Warn("Environment file is larger than expected")
+	}
+
+	for _, line := range strings.Split(string(buf), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		left := strings.TrimSpace(parts[0])
+		if left == "" || left[0] == '#' {
+			continue
+		}
+
+		os.Setenv(left, strings.TrimSpace(parts[1]))
+	}
+
+	return nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Overlay PENNY_* environment variables
+func applyEnv(cfg *Config) []error {
+	var errs []error
+	lookup := func(key string) (string, bool) {
+		return os.LookupEnv("PENNY_" + key)
+	}
+
+	if v, ok := lookup("HOST"); ok {
+		cfg.Host = v
+	}
+	if v, ok := lookup("PORT"); ok {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("PENNY_PORT: invalid port %q", v))
+		} else {
+			cfg.Port = port
+		}
+	}
+	if v, ok := lookup("BASE_URL"); ok {
+		cfg.BaseUrl = v
+	}
+	if v, ok := lookup("PUBLIC_URL"); ok {
+		cfg.PublicUrl = v
+	}
+	if v, ok := lookup("RENDER_MARKDOWN"); ok {
+		render, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("PENNY_RENDER_MARKDOWN: invalid boolean %q", v))
+		} else {
+			cfg.RenderMD = render
+		}
+	}
+	if v, ok := lookup("PROVIDERS"); ok {
+		cfg.Providers = splitList(v)
+	}
+	if v, ok := lookup("ENV_FILE"); ok {
+		cfg.EnvFilename = v
+	}
+	if v, ok := lookup("FILTERS"); ok {
+		cfg.EnabledFilters = splitList(v)
+	}
+	if v, ok := lookup("MARKDOWN_EXTENSIONS"); ok {
+		cfg.Markdown.Extensions = splitList(v)
+	}
+	if v, ok := lookup("DATABASE"); ok {
+		cfg.DbFile = v
+	}
+	if v, ok := lookup("TRUSTED_ORIGINS"); ok {
+		cfg.TrustedOrigins = splitList(v)
+	}
+
+	return errs
+}
+
+// Flags overriding config values, only flags set on the command line are applied
+type flagValues struct {
+	values map[string]func()
+}
+
+func newFlagSet(cfg *Config) (*flag.FlagSet, flagValues) {
+	fs := flag.NewFlagSet("penny", flag.ContinueOnError)
+	fv := flagValues{make(map[string]func())}
+
+	str := func(name string, usage string, dst *string) {
+		v := fs.String(name, "", usage)
+		fv.values[name] = func() { *dst = *v }
+	}
+	list := func(name string, usage string, dst *[]string) {
+		v := fs.String(name, "", usage+", comma separated")
+		fv.values[name] = func() { *dst = splitList(*v) }
+	}
+
+	str("host", "hostname to listen on", &cfg.Host)
+	port := fs.Int("port", 0, "port to listen on")
+	fv.values["port"] = func() { cfg.Port = *port }
+	str("base-url", "path penny is served under", &cfg.BaseUrl)
+	str("public-url", "url penny is reachable at", &cfg.PublicUrl)
+	render := fs.Bool("render-markdown", false, "render comments as markdown")
+	fv.values["render-markdown"] = func() { cfg.RenderMD = *render }
+	list("providers", "oauth providers to sign in with", &cfg.Providers)
+	str("env-file", "file with secrets to load into the environment", &cfg.EnvFilename)
+	list("filters", "filters to run over comments", &cfg.EnabledFilters)
+	list("markdown-extensions", "markdown extensions to enable", &cfg.Markdown.Extensions)
+	str("database", "database connection string", &cfg.DbFile)
+	list("trusted-origins", "origins allowed to embed penny", &cfg.TrustedOrigins)
+
+	return fs, fv
+}
+
+func (fv flagValues) apply(fs *flag.FlagSet) {
+	fs.Visit(func(f *flag.Flag) {
+		if apply, ok := fv.values[f.Name]; ok {
+			apply()
+		}
+	})
+}
@@ -0,0 +1,306 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jpappel/penny/config"
+)
+
+// Isolate a test from config files and env vars on the machine running it
+func isolate(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	t.Setenv("HOME", dir)
+	for _, env := range os.Environ() {
+		if key, _, _ := strings.Cut(env, "="); strings.HasPrefix(key, "PENNY_") {
+			t.Setenv(key, "")
+			os.Unsetenv(key)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func writeFile(t *testing.T, filename string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	isolate(t)
+
+	cfg, args, err := config.Load(nil)
+	if err != nil {
+		t.Fatal("Unexpected error loading defaults:", err)
+	}
+	if len(args) != 0 {
+		t.Error("Expected no remaining args, got", args)
+	}
+	if cfg.Port != 8080 {
+		t.Error("Expected default port 8080, got", cfg.Port)
+	}
+	if cfg.PublicUrl != "http://localhost:8080" {
+		t.Error("Unexpected public url", cfg.PublicUrl)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := isolate(t)
+
+	writeFile(t, filepath.Join(dir, "xdg", "penny", "config.json"),
+		`{"hostname": "xdg", "port": 1000, "base_url": "xdg", "database": "xdg.sqlite3"}`)
+	writeFile(t, filepath.Join(dir, "custom.json"), `{"port": 2000, "base_url": "custom/"}`)
+	t.Setenv("PENNY_PORT", "3000")
+	t.Setenv("PENNY_TRUSTED_ORIGINS", "https://a.example.com, https://b.example.com")
+
+	cfg, args, err := config.Load([]string{"-config", "custom.json", "-port", "4000", "migrate", "status"})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if cfg.Host != "xdg" {
+		t.Errorf("Expected host from xdg config, got %q", cfg.Host)
+	}
+	if cfg.DbFile != "xdg.sqlite3" {
+		t.Errorf("Expected database from xdg config, got %q", cfg.DbFile)
+	}
+	if cfg.BaseUrl != "custom" {
+		t.Errorf("Expected base url from -config file, got %q", cfg.BaseUrl)
+	}
+	if cfg.Port != 4000 {
+		t.Errorf("Expected port from flag, got %d", cfg.Port)
+	}
+	if !slices.Equal(cfg.TrustedOrigins, []string{"https://a.example.com", "https://b.example.com"}) {
+		t.Error("Unexpected trusted origins", cfg.TrustedOrigins)
+	}
+	if !slices.Equal(args, []string{"migrate", "status"}) {
+		t.Error("Unexpected remaining args", args)
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	dir := isolate(t)
+
+	writeFile(t, filepath.Join(dir, "secrets"), "# comment\nPENNY_SESSION_SECRET = hunter2\nPENNY_PORT=5000\n")
+	t.Setenv("PENNY_ENV_FILE", "secrets")
+
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if string(cfg.SessionSecret) != "hunter2" {
+		t.Errorf("Expected session secret from env file, got %q", cfg.SessionSecret)
+	}
+	if cfg.Port != 5000 {
+		t.Error("Expected port from env file, got", cfg.Port)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := isolate(t)
+
+	writeFile(t, filepath.Join(dir, "bad.json"), `{
+		"port": 70000,
+		"base_url": "https://example.com/penny",
+		"public_url": "ftp://example.com",
+		"filters": ["noSuchFilter"],
+		"providers": ["GitHub", "MySpace"],
+		"trusted_origins": ["https://example.com/blog"],
+		"markdown": {"extensions": ["table", "katex"]},
+		"admins": {"friendster": ["jp@jpappel.xyz"], "github": ["jpappel"]},
+		"purge_after": -1,
+		"edit_window": -60
+	}`)
+
+	t.Setenv("PENNY_PORT", "eighty")
+	t.Setenv("PENNY_RENDER_MARKDOWN", "maybe")
+
+	_, _, err := config.Load([]string{"-config", "bad.json", "-env-file", "missing.env"})
+	if err == nil {
+		t.Fatal("Expected an error for an invalid config")
+	}
+
+	msg := err.Error()
+	for _, want := range []string{
+		"missing.env",
+		"port:",
+		"base_url:",
+		"public_url:",
+		`"noSuchFilter"`,
+		`"MySpace"`,
+		"GITHUB_CLIENT_ID",
+		"trusted_origins:",
+		`"katex"`,
+		`"friendster"`,
+		`"jpappel" is not an email`,
+		"purge_after:",
+		"edit_window:",
+		"PENNY_PORT:",
+		"PENNY_RENDER_MARKDOWN:",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Expected error to mention %s, got:\n%s", want, msg)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(msg, "\n") {
+		if seen[line] {
+			t.Errorf("Expected each error once, got %q again", line)
+		}
+		seen[line] = true
+	}
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	dir := isolate(t)
+
+	writeFile(t, filepath.Join(dir, "typo.json"), `{"prot": 9000}`)
+
+	_, _, err := config.Load([]string{"-config", "typo.json"})
+	if err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Error("Expected an error for an unknown field, got", err)
+	}
+}
+
+func TestRenderer(t *testing.T) {
+	dir := isolate(t)
+
+	writeFile(t, filepath.Join(dir, "md.json"), `{
+		"render_markdown": true,
+		"markdown": {"class_names": {"heading": "my-heading"}}
+	}`)
+
+	cfg, _, err := config.Load([]string{"-config", "md.json"})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	r, err := cfg.Renderer()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	html, err := r.Render("# Title\n\n> quote")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !strings.Contains(html, `<h3 class="my-heading">`) || !strings.Contains(html, `<blockquote class="penny-quote">`) {
+		t.Error("Expected penny classes with overrides, got", html)
+	}
+
+	cfg.Markdown.Extensions = append(cfg.Markdown.Extensions, "math")
+	if r, err = cfg.Renderer(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if html, _ := r.Render("$x$"); !strings.Contains(html, `<span class="penny-math">`) {
+		t.Error("Expected math extension to be enabled, got", html)
+	}
+
+	cfg.Markdown.PennyClasses = false
+	if r, err = cfg.Renderer(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if html, _ := r.Render("# Title"); !strings.Contains(html, "<h1>Title</h1>") {
+		t.Error("Expected plain markdown without penny classes, got", html)
+	}
+}
+
+func TestWordFilters(t *testing.T) {
+	dir := isolate(t)
+
+	writeFile(t, filepath.Join(dir, "fruit.txt"), "apple\n")
+	writeFile(t, filepath.Join(dir, "words.json"), `{
+		"filters": ["fruit", "strict"],
+		"word_filters": {
+			"fruit": {"file": "fruit.txt", "replacement": "****"},
+			"strict": {"file": "fruit.txt", "mode": "reject"}
+		}
+	}`)
+
+	cfg, _, err := config.Load([]string{"-config", "words.json", "-filters", "fruit"})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	r, err := cfg.Renderer()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if html, _ := r.Render("an apple"); html != "<p>an ****</p>\n" {
+		t.Errorf("Expected word to be replaced, got %q", html)
+	}
+
+	cfg.EnabledFilters = []string{"strict"}
+	if r, err = cfg.Renderer(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if _, err := r.Render("an apple"); err == nil {
+		t.Error("Expected comment to be rejected")
+	}
+
+	writeFile(t, filepath.Join(dir, "phrases.txt"), "rotten apple\n")
+	cfg.WordFilters["fruit"] = config.WordFilterConfig{File: "phrases.txt", Replacement: "****", Phrases: true}
+	cfg.EnabledFilters = []string{"fruit"}
+	if r, err = cfg.Renderer(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if html, _ := r.Render("a Rotten  apple"); html != "<p>a ****</p>\n" {
+		t.Errorf("Expected phrase to be replaced, got %q", html)
+	}
+
+	writeFile(t, filepath.Join(dir, "bad.json"), `{
+		"filters": ["fruit"],
+		"word_filters": {"fruit": {"file": "missing.txt", "mode": "shout"}}
+	}`)
+	_, _, err = config.Load([]string{"-config", "bad.json"})
+	if err == nil || !strings.Contains(err.Error(), "missing.txt") || !strings.Contains(err.Error(), `"shout"`) {
+		t.Error("Expected errors for a missing file and unknown mode, got", err)
+	}
+}
+
+func TestRegexFilters(t *testing.T) {
+	dir := isolate(t)
+
+	writeFile(t, filepath.Join(dir, "regex.json"), `{
+		"filters": ["email", "orders"],
+		"regex_filters": {
+			"orders": {"pattern": "ORD-(\\d+)", "replacement": "order $1"}
+		}
+	}`)
+
+	cfg, _, err := config.Load([]string{"-config", "regex.json"})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	r, err := cfg.Renderer()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if html, _ := r.Render("mail jp@jpappel.xyz about ORD-42"); html != "<p>mail [email] about order 42</p>\n" {
+		t.Errorf("Expected email and order to be replaced, got %q", html)
+	}
+
+	writeFile(t, filepath.Join(dir, "bad.json"), `{
+		"regex_filters": {"phone": {"pattern": "x"}, "broken": {"pattern": "(unclosed"}}
+	}`)
+	_, _, err = config.Load([]string{"-config", "bad.json"})
+	if err == nil || !strings.Contains(err.Error(), "regex_filters.phone") || !strings.Contains(err.Error(), "regex_filters.broken") {
+		t.Error("Expected errors for a built in name and invalid pattern, got", err)
+	}
+}
@@ -0,0 +1,265 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/yuin/goldmark"
+
+	"github.com/jpappel/penny/auth"
+	"github.com/jpappel/penny/filters"
+)
+
+// Check every value in the configuration, returning all problems found
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		invalid("port", "%d is not between 1 and 65535", c.Port)
+	}
+
+	if strings.Contains(c.BaseUrl, "://") || strings.HasPrefix(c.BaseUrl, "//") {
+		invalid("base_url", "%q must be a path, not a url", c.BaseUrl)
+	} else if strings.ContainsAny(c.BaseUrl, "?#") {
+		invalid("base_url", "%q cannot contain a query or fragment", c.BaseUrl)
+	}
+
+	if u, err := url.Parse(c.PublicUrl); err != nil {
+		invalid("public_url", "%v", err)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		invalid("public_url", "%q must be an http or https url", c.PublicUrl)
+	}
+
+	for _, origin := range c.TrustedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			invalid("trusted_origins", "%q must be a scheme and host like https://example.com", origin)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.WordFilters)) {
+		wf := c.WordFilters[name]
+		field := fmt.Sprintf("word_filters.%s", name)
+		if _, ok := filters.AvailableFilters[name]; ok {
+			invalid(field, "name is already used by a built in filter")
+		}
+		if _, err := wf.action(); err != nil {
+			invalid(field, "%v", err)
+		}
+		if wf.File == "" {
+			invalid(field, "file cannot be empty")
+		} else if f, err := os.Open(wf.File); err != nil {
+			invalid(field, "%v", err)
+		} else {
+			f.Close()
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.RegexFilters)) {
+		rf := c.RegexFilters[name]
+		field := fmt.Sprintf("regex_filters.%s", name)
+		if _, ok := filters.AvailableFilters[name]; ok {
+			invalid(field, "name is already used by a built in filter")
+		} else if _, ok := c.WordFilters[name]; ok {
+			invalid(field, "name is already used by a word filter")
+		}
+		if rf.Pattern == "" {
+			invalid(field, "pattern cannot be empty")
+		} else if _, err := regexp.Compile(rf.Pattern); err != nil {
+			invalid(field, "%v", err)
+		}
+	}
+
+	for _, name := range c.EnabledFilters {
+		_, builtin := filters.AvailableFilters[name]
+		_, wordFilter := c.WordFilters[name]
+		_, regexFilter := c.RegexFilters[name]
+		if !builtin && !wordFilter && !regexFilter {
+			invalid("filters", "no filter named %q", name)
+		}
+	}
+
+	for _, name := range c.Markdown.Extensions {
+		if _, ok := filters.MarkdownExtensions[name]; !ok {
+			invalid("markdown.extensions", "no markdown extension named %q", name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range c.Providers {
+		key := strings.ToLower(name)
+		if !slices.ContainsFunc(auth.SupportedProviders, func(p string) bool { return strings.ToLower(p) == key }) {
+			invalid("providers", "unsupported provider %q, expected one of %s", name, strings.Join(auth.SupportedProviders, ", "))
+			continue
+		} else if seen[key] {
+			invalid("providers", "%q is listed more than once", name)
+			continue
+		}
+		seen[key] = true
+
+		env := strings.ToUpper(name)
+		for _, suffix := range []string{"_CLIENT_ID", "_CLIENT_SECRET"} {
+			if strings.TrimSpace(os.Getenv(env+suffix)) == "" {
+				invalid("providers", "%s%s is not set for %s", env, suffix, name)
+			}
+		}
+	}
+
+	for _, provider := range slices.Sorted(maps.Keys(c.Admins)) {
+		key := strings.ToLower(provider)
+		if !slices.ContainsFunc(auth.SupportedProviders, func(p string) bool { return strings.ToLower(p) == key }) {
+			invalid("admins", "unsupported provider %q, expected one of %s", provider, strings.Join(auth.SupportedProviders, ", "))
+		}
+		for _, email := range c.Admins[provider] {
+			if !strings.Contains(email, "@") {
+				invalid("admins", "%q is not an email address", email)
+			}
+		}
+	}
+
+	if c.DbFile == "" {
+		invalid("database", "cannot be empty")
+	}
+	if c.DbPool.MaxOpenConns < 0 || c.DbPool.MaxIdleConns < 0 ||
+		c.DbPool.ConnMaxLifetime < 0 || c.DbPool.ConnMaxIdleTime < 0 {
+		invalid("database_pool", "values cannot be negative")
+	}
+	if c.Session.Lifetime < 0 || c.Session.Rotate < 0 {
+		invalid("session", "values cannot be negative")
+	}
+	if c.PurgeAfter < 0 {
+		invalid("purge_after", "cannot be negative")
+	}
+	if c.EditWindow < 0 {
+		invalid("edit_window", "cannot be negative")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Create the renderer for the enabled filters and markdown settings
+func (c Config) Renderer() (filters.Renderer, error) {
+	var r filters.Renderer
+	for _, name := range c.EnabledFilters {
+		filter, err := c.filter(name)
+		if err != nil {
+			return filters.Renderer{}, err
+		}
+		r.Filters = append(r.Filters, filter)
+	}
+	if c.RenderMD {
+		var extensions []goldmark.Extender
+		for _, name := range c.Markdown.Extensions {
+			extension, ok := filters.MarkdownExtensions[name]
+			if !ok {
+				return filters.Renderer{}, fmt.Errorf("no markdown extension named %q", name)
+			}
+			extensions = append(extensions, extension)
+		}
+		if c.Markdown.PennyClasses {
+			extensions = append(extensions, filters.PennyClasses(c.Markdown.classNames()))
+		}
+		r.Markdown = filters.NewMarkdownConverter(extensions...)
+	}
+	return r, nil
+}
+
+// Get a configured word or regex filter or built in filter by name
+func (c Config) filter(name string) (filters.Filterer, error) {
+	if wf, ok := c.WordFilters[name]; ok {
+		action, err := wf.action()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		options := filters.WordFilter{
+			Replacement:    wf.Replacement,
+			Action:         action,
+			Substring:      wf.Substring,
+			Leetspeak:      wf.Leetspeak,
+			PreserveLength: wf.PreserveLength,
+		}
+		newFilter := filters.NewWordListFilter
+		if wf.Phrases {
+			newFilter = filters.NewPhraseListFilter
+		}
+		filter, err := newFilter(wf.File, options)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return filter, nil
+	}
+
+	if rf, ok := c.RegexFilters[name]; ok {
+		pattern, err := filters.NewRegexPattern(name, rf.Pattern, rf.Replacement)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return filters.RegexFilter{Patterns: []filters.RegexPattern{pattern}}, nil
+	}
+
+	filter, ok := filters.AvailableFilters[name]
+	if !ok {
+		return nil, fmt.Errorf("no filter named %q", name)
+	}
+	return filter, nil
+}
+
+func (wf WordFilterConfig) action() (filters.WordAction, error) {
+	switch wf.Mode {
+	case "", "replace":
+		return filters.ReplaceWords, nil
+	case "reject":
+		return filters.RejectWords, nil
+	case "hold":
+		return filters.HoldWords, nil
+	default:
+		return 0, fmt.Errorf("unknown mode %q, expected replace, reject or hold", wf.Mode)
+	}
+}
+
+func (m MarkdownConfig) classNames() filters.ClassNames {
+	names := filters.DefaultClassNames
+	override := func(dst *string, name string) {
+		if name != "" {
+			*dst = name
+		}
+	}
+	override(&names.Heading, m.ClassNames.Heading)
+	override(&names.CodeBlock, m.ClassNames.CodeBlock)
+	override(&names.Blockquote, m.ClassNames.Blockquote)
+	override(&names.Link, m.ClassNames.Link)
+	override(&names.Table, m.ClassNames.Table)
+	return names
+}
+
+// Create the configured OAuth providers from credentials in the environment
+func (c Config) OAuthProviders() ([]*auth.OAuthProvider, error) {
+	var providers []*auth.OAuthProvider
+	for _, name := range c.Providers {
+		key := strings.ToUpper(name)
+		clientId := strings.TrimSpace(os.Getenv(key + "_CLIENT_ID"))
+		clientSecret := strings.TrimSpace(os.Getenv(key + "_CLIENT_SECRET"))
+
+		callbackUrl := fmt.Sprintf("%s/auth/%s/callback", c.PublicUrl, strings.ToLower(name))
+		if c.BaseUrl != "" {
+			callbackUrl = fmt.Sprintf("%s/%s/auth/%s/callback", c.PublicUrl, c.BaseUrl, strings.ToLower(name))
+		}
+
+		provider, err := auth.NewOAuthProvider(name, clientId, clientSecret, callbackUrl)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
@@ -1,7 +1,9 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	_ "github.com/tursodatabase/go-libsql"
 )
@@ -17,73 +19,25 @@ func NewConn(connStr string) *sql.DB {
 	return db
 }
 
-func initUsers(db *sql.DB) {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS Users(
-        id INTEGER PRIMARY KEY,
-        email TEXT,
-        provider TEXT NOT NULL,
-        name TEXT,
-        UNIQUE(email, provider)
-    )`)
-	if err != nil {
-		panic(err)
-	}
-}
-
-func initPages(db *sql.DB) {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS Pages(
-        id INTEGER PRIMARY KEY,
-        url TEXT UNIQUE NOT NULL,
-        commentsOpenTime INTEGER
-    )`)
-	if err != nil {
-		panic(err)
-	}
-}
-
-func initComments(db *sql.DB) {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS Comments(
-        id INTEGER PRIMARY KEY,
-        userId INTEGER NOT NULL,
-        pageId INTEGER NOT NULL,
-        hiddenTime INTEGER,
-        deletedTime INTEGER,
-        postedTime INTEGER NOT NULL,
-        content TEXT NOT NULL,
-        FOREIGN KEY(userId) REFERENCES Users(id),
-        FOREIGN KEY(pageId) REFERENCES Pages(id)
-    )`)
-	if err != nil {
-		panic(err)
-	}
-	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_users ON Comments(userId)")
-	if err != nil {
-		panic(err)
-	}
-	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_postedTime ON Comments(postedTime)")
+// Bring the database schema up to date, panicking if it cannot be migrated
+func InitDB(db *sql.DB) {
+	ctx := context.Background()
+	latest, err := LatestVersion()
 	if err != nil {
 		panic(err)
 	}
-}
 
-func initReplies(db *sql.DB) {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS Replies(
-        id INTEGER PRIMARY KEY,
-        parentId INTEGER NOT NULL,
-        childId INTEGER NOT NULL,
-        FOREIGN KEY(parentId) REFERENCES Comments(id),
-        FOREIGN KEY(childId) REFERENCES Comments(id)
-    )`)
-	if err != nil {
+	if err := Migrate(ctx, db, latest); err != nil {
 		panic(err)
 	}
 }
 
-func InitDB(db *sql.DB) {
-	initUsers(db)
-	initPages(db)
-	initComments(db)
-	initReplies(db)
+// Connection pool limits, zero values keep the database/sql defaults
+type PoolConfig struct {
+	MaxOpenConns    int `json:"max_open_conns"`
+	MaxIdleConns    int `json:"max_idle_conns"`
+	ConnMaxLifetime int `json:"conn_max_lifetime"`  // seconds
+	ConnMaxIdleTime int `json:"conn_max_idle_time"` // seconds
 }
 
 func New(connStr string) PennyDB {
@@ -92,3 +46,27 @@ func New(connStr string) PennyDB {
 
 	return PennyDB{db}
 }
+
+// Create a PennyDB with a configured connection pool
+func NewPool(connStr string, pool PoolConfig) PennyDB {
+	pdb := New(connStr)
+
+	if pool.MaxOpenConns > 0 {
+		pdb.Db.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		pdb.Db.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		pdb.Db.SetConnMaxLifetime(time.Duration(pool.ConnMaxLifetime) * time.Second)
+	}
+	if pool.ConnMaxIdleTime > 0 {
+		pdb.Db.SetConnMaxIdleTime(time.Duration(pool.ConnMaxIdleTime) * time.Second)
+	}
+
+	return pdb
+}
+
+func (p PennyDB) Close() error {
+	return p.Db.Close()
+}
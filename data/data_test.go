@@ -54,6 +54,9 @@ func (tc CommentsTestCase) Test(t *testing.T) {
 			if c.Hidden != e.Hidden {
 				t.Logf("Different Hidden status: wanted %t, got %t\n", e.Hidden, c.Hidden)
 			}
+			if c.Depth != e.Depth {
+				t.Logf("Different Depth: wanted %d, got %d\n", e.Depth, c.Depth)
+			}
 			if !c.Posted.Equal(e.Posted) {
 				t.Logf("Different Posted Time: wanted %v, got %v\n", e.Posted, c.Posted)
 			}
@@ -184,10 +187,10 @@ func nestedCommentChain(connStr string) data.PennyDB {
 	}
 
 	_, err = tx.Exec(`
-    INSERT INTO Replies(parentId, childId)
-    VALUES (?,?),(?,?)`,
-		1, 2,
-		2, 3)
+    INSERT INTO Replies(parentId, childId, depth)
+    VALUES (?,?,?),(?,?,?)`,
+		1, 2, 1,
+		2, 3, 2)
 	if err != nil {
 		panic(err)
 	}
@@ -273,7 +276,7 @@ func commentForest(connStr string) data.PennyDB {
 	}
 	stmt.Close()
 
-	stmt, err = tx.Prepare("INSERT INTO Replies(parentId, childId) VALUES (?,?)")
+	stmt, err = tx.Prepare("INSERT INTO Replies(parentId, childId, depth) VALUES (?,?,?)")
 	if err != nil {
 		panic(err)
 	}
@@ -281,13 +284,14 @@ func commentForest(connStr string) data.PennyDB {
 	relations := []struct {
 		parent int
 		self   int
+		depth  int
 	}{
-		{1, 4}, {1, 5}, {4, 8}, {4, 9},
-		{2, 6}, {6, 7},
-		{3, 10}, {3, 11}, {3, 12}, {3, 13},
+		{1, 4, 1}, {1, 5, 1}, {4, 8, 2}, {4, 9, 2},
+		{2, 6, 1}, {6, 7, 2},
+		{3, 10, 1}, {3, 11, 1}, {3, 12, 1}, {3, 13, 1},
 	}
 	for _, r := range relations {
-		_, err = stmt.Exec(r.parent, r.self)
+		_, err = stmt.Exec(r.parent, r.self, r.depth)
 		if err != nil {
 			panic(err)
 		}
@@ -305,36 +309,33 @@ var commentForestPage *data.Page
 
 func init() {
 	singleCommentPage = &data.Page{
-		Url:        "apples",
-		UpdateTime: time.Unix(MaxInt64, 0),
-		Comments:   []data.Comment{{1, "pie", false, false, time.Unix(0, 0), nil}},
+		PageInfo: data.PageInfo{Url: "apples", UpdateTime: time.Unix(MaxInt64, 0)},
+		Comments: []data.Comment{{Id: 1, Content: "pie", Posted: time.Unix(0, 0), Replies: nil}},
 	}
 
 	nestedCommentChainPage = &data.Page{
-		Url:        "peaches",
-		UpdateTime: time.Unix(MaxInt64, 0),
+		PageInfo: data.PageInfo{Url: "peaches", UpdateTime: time.Unix(MaxInt64, 0)},
 		Comments: []data.Comment{
-			{1, "cobbler", false, false, time.Unix(0, 0), []int{2}},
-			{2, "with", false, false, time.Unix(1, 0), []int{3}},
-			{3, "icecream", false, false, time.Unix(2, 0), nil},
+			{Id: 1, Content: "cobbler", Posted: time.Unix(0, 0), Replies: []int{2}},
+			{Id: 2, Content: "with", Posted: time.Unix(1, 0), Replies: []int{3}, Depth: 1},
+			{Id: 3, Content: "icecream", Posted: time.Unix(2, 0), Replies: nil, Depth: 2},
 		}}
 
 	commentForestPage = &data.Page{
-		Url:        "the",
-		UpdateTime: time.Unix(MaxInt64, 0),
+		PageInfo: data.PageInfo{Url: "the", UpdateTime: time.Unix(MaxInt64, 0)},
 		Comments: []data.Comment{
 			{Id: 1, Content: "first", Posted: time.Unix(0, 0), Replies: []int{4, 5}},
 			{Id: 2, Content: "second", Posted: time.Unix(1, 0), Replies: []int{6}},
 			{Id: 3, Content: "last", Posted: time.Unix(2, 0), Replies: []int{10, 11, 12, 13}},
-			{Id: 4, Content: "letter", Posted: time.Unix(3, 0), Replies: []int{8, 9}},
-			{Id: 5, Content: "animal", Posted: time.Unix(3, 0), Replies: nil},
-			{Id: 6, Content: "ammendment", Posted: time.Unix(4, 0), Replies: []int{7}},
-			{Id: 7, Content: "of the US constitution is the right to bear arms", Posted: time.Unix(5, 0), Replies: nil},
-			{Id: 8, Content: "of the english alphabet descends from proto-sinatic script", Posted: time.Unix(5, 0), Replies: nil},
-			{Id: 9, Content: "is an inverted bull", Posted: time.Unix(5, 0), Replies: nil},
-			{Id: 10, Content: "christmas", Posted: time.Unix(7, 0), Replies: nil},
-			{Id: 11, Content: "I gave you my heart", Posted: time.Unix(8, 0), Replies: nil},
-			{Id: 12, Content: "but then the very next day", Posted: time.Unix(9, 0), Replies: nil},
-			{Id: 13, Content: "you gave it away", Posted: time.Unix(10, 0), Replies: nil},
+			{Id: 4, Content: "letter", Posted: time.Unix(3, 0), Replies: []int{8, 9}, Depth: 1},
+			{Id: 5, Content: "animal", Posted: time.Unix(3, 0), Replies: nil, Depth: 1},
+			{Id: 6, Content: "ammendment", Posted: time.Unix(4, 0), Replies: []int{7}, Depth: 1},
+			{Id: 7, Content: "of the US constitution is the right to bear arms", Posted: time.Unix(5, 0), Replies: nil, Depth: 2},
+			{Id: 8, Content: "of the english alphabet descends from proto-sinatic script", Posted: time.Unix(5, 0), Replies: nil, Depth: 2},
+			{Id: 9, Content: "is an inverted bull", Posted: time.Unix(5, 0), Replies: nil, Depth: 2},
+			{Id: 10, Content: "christmas", Posted: time.Unix(7, 0), Replies: nil, Depth: 1},
+			{Id: 11, Content: "I gave you my heart", Posted: time.Unix(8, 0), Replies: nil, Depth: 1},
+			{Id: 12, Content: "but then the very next day", Posted: time.Unix(9, 0), Replies: nil, Depth: 1},
+			{Id: 13, Content: "you gave it away", Posted: time.Unix(10, 0), Replies: nil, Depth: 1},
 		}}
 }
@@ -0,0 +1,281 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+var ErrNewerSchema error = errors.New("Database schema is newer than any known migration")
+var ErrNoMigration error = errors.New("No matching migration")
+
+// A single schema change, applied with Up and reverted with Down
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// A migration that has been applied to a database
+type AppliedMigration struct {
+	Version int
+	Name    string
+	Applied time.Time
+}
+
+// Parse the embedded migrations, ordered by version
+//
+// Migrations are named `<version>_<name>.up.sql` and `<version>_<name>.down.sql`
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		filename := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(filename, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("Invalid migration filename %s", filename)
+		}
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("Invalid migration filename %s", filename)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("Invalid migration version in %s", filename)
+		}
+
+		buf, err := migrationFS.ReadFile(path.Join("migrations", filename))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("Conflicting names for migration %d: %s and %s", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(buf)
+		} else {
+			m.Down = string(buf)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("Migration %d_%s is missing an up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("Missing migration %d", i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// The version of the newest known migration
+func LatestVersion() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	return len(migrations), nil
+}
+
+func initSchemaMigrations(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS SchemaMigrations(
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        appliedTime INTEGER NOT NULL
+    )`)
+	return err
+}
+
+// Get the migrations applied to a database, ordered by version
+func AppliedMigrations(ctx context.Context, db *sql.DB) ([]AppliedMigration, error) {
+	if err := initSchemaMigrations(ctx, db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version, name, appliedTime FROM SchemaMigrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make([]AppliedMigration, 0, 8)
+	for rows.Next() {
+		var m AppliedMigration
+		var appliedTime int64
+		if err := rows.Scan(&m.Version, &m.Name, &appliedTime); err != nil {
+			return nil, err
+		}
+		m.Applied = time.Unix(appliedTime, 0)
+		applied = append(applied, m)
+	}
+
+	return applied, rows.Err()
+}
+
+// Get the current schema version of a database, 0 if no migrations have been applied
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	if err := initSchemaMigrations(ctx, db); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM SchemaMigrations").Scan(&version)
+	return version, err
+}
+
+// Apply or revert migrations until the database is at the target version
+//
+// Each migration runs in its own transaction.
+// Returns ErrNewerSchema if the database is newer than the latest known migration.
+func Migrate(ctx context.Context, db *sql.DB, target int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	if target < 0 || target > len(migrations) {
+		return ErrNoMigration
+	}
+
+	current, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return ErrNewerSchema
+	}
+
+	for current < target {
+		m := migrations[current]
+		if err := applyMigration(ctx, db, m.Up, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO SchemaMigrations(version, name, appliedTime) VALUES (?,?,?)",
+				m.Version, m.Name, time.Now().UTC().Unix())
+			return err
+		}); err != nil {
+			return fmt.Errorf("Failed to apply migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		current++
+	}
+
+	for current > target {
+		m := migrations[current-1]
+		if err := applyMigration(ctx, db, m.Down, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "DELETE FROM SchemaMigrations WHERE version = ?", m.Version)
+			return err
+		}); err != nil {
+			return fmt.Errorf("Failed to revert migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		current--
+	}
+
+	return nil
+}
+
+// Run a migration script and record it in a single transaction
+func applyMigration(ctx context.Context, db *sql.DB, script string, record func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Split a sql script into individual statements
+//
+// The libsql driver only executes the first statement given to Exec.
+// Semicolons inside quotes and comments are ignored, triggers are not supported.
+func splitStatements(script string) []string {
+	stmts := make([]string, 0, 8)
+	var b strings.Builder
+	var quote byte
+	inLineComment, inBlockComment := false, false
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case inLineComment:
+			if c == '\n' {
+				inLineComment = false
+			}
+			continue
+		case inBlockComment:
+			if c == '*' && i+1 < len(script) && script[i+1] == '/' {
+				inBlockComment = false
+				i++
+			}
+			continue
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && i+1 < len(script) && script[i+1] == '-':
+			inLineComment = true
+			continue
+		case c == '/' && i+1 < len(script) && script[i+1] == '*':
+			inBlockComment = true
+			i++
+			continue
+		case c == ';':
+			if stmt := strings.TrimSpace(b.String()); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			b.Reset()
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	if stmt := strings.TrimSpace(b.String()); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+
+	return stmts
+}
@@ -0,0 +1,238 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jpappel/penny/data"
+)
+
+func tableExists(t *testing.T, pdb data.PennyDB, name string) bool {
+	t.Helper()
+	var n int
+	err := pdb.Db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n == 1
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	pdb := data.PennyDB{Db: data.NewConn(fmt.Sprintf("file:%s/migrate.db", t.TempDir()))}
+	defer pdb.Db.Close()
+
+	latest, err := data.LatestVersion()
+	if err != nil {
+		t.Fatal("Invalid migrations:", err)
+	}
+
+	if err := data.Migrate(ctx, pdb.Db, latest); err != nil {
+		t.Fatal("Failed to migrate up:", err)
+	}
+	if v, err := data.SchemaVersion(ctx, pdb.Db); err != nil || v != latest {
+		t.Fatalf("Unexpected schema version: wanted %d got %d (%v)\n", latest, v, err)
+	}
+	for _, table := range []string{"Users", "Pages", "Comments", "Replies", "Sessions"} {
+		if !tableExists(t, pdb, table) {
+			t.Error("Missing table after migrating up:", table)
+		}
+	}
+
+	// migrating to the current version is a noop
+	if err := data.Migrate(ctx, pdb.Db, latest); err != nil {
+		t.Fatal("Failed to migrate to current version:", err)
+	}
+
+	if err := data.Migrate(ctx, pdb.Db, 0); err != nil {
+		t.Fatal("Failed to migrate down:", err)
+	}
+	if v, err := data.SchemaVersion(ctx, pdb.Db); err != nil || v != 0 {
+		t.Fatalf("Unexpected schema version: wanted 0 got %d (%v)\n", v, err)
+	}
+	for _, table := range []string{"Users", "Pages", "Comments", "Replies", "Sessions"} {
+		if tableExists(t, pdb, table) {
+			t.Error("Table remains after migrating down:", table)
+		}
+	}
+
+	if err := data.Migrate(ctx, pdb.Db, latest+1); err != data.ErrNoMigration {
+		t.Errorf("Unexpected error: wanted `%v` got `%v`\n", data.ErrNoMigration, err)
+	}
+}
+
+func TestMigrateNewerSchema(t *testing.T) {
+	ctx := context.Background()
+	pdb := data.New(fmt.Sprintf("file:%s/newer.db", t.TempDir()))
+	defer pdb.Db.Close()
+
+	latest, err := data.LatestVersion()
+	if err != nil {
+		t.Fatal("Invalid migrations:", err)
+	}
+
+	_, err = pdb.Db.Exec("INSERT INTO SchemaMigrations(version, name, appliedTime) VALUES (?, 'future', 0)", latest+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := data.Migrate(ctx, pdb.Db, latest); err != data.ErrNewerSchema {
+		t.Errorf("Unexpected error: wanted `%v` got `%v`\n", data.ErrNewerSchema, err)
+	}
+}
+
+func TestMigrateLegacySchema(t *testing.T) {
+	ctx := context.Background()
+	pdb := data.PennyDB{Db: data.NewConn(fmt.Sprintf("file:%s/legacy.db", t.TempDir()))}
+	defer pdb.Db.Close()
+
+	// schema and data as created by InitDB before migrations existed
+	legacy := []string{
+		`CREATE TABLE Users(id INTEGER PRIMARY KEY, email TEXT, provider TEXT NOT NULL, name TEXT, UNIQUE(email, provider))`,
+		`CREATE TABLE Pages(id INTEGER PRIMARY KEY, url TEXT UNIQUE NOT NULL, commentsOpenTime INTEGER)`,
+		`CREATE TABLE Comments(
+            id INTEGER PRIMARY KEY,
+            userId INTEGER NOT NULL,
+            pageId INTEGER NOT NULL,
+            hiddenTime INTEGER,
+            deletedTime INTEGER,
+            postedTime INTEGER NOT NULL,
+            content TEXT NOT NULL,
+            FOREIGN KEY(userId) REFERENCES Users(id),
+            FOREIGN KEY(pageId) REFERENCES Pages(id)
+        )`,
+		`CREATE TABLE Replies(
+            id INTEGER PRIMARY KEY,
+            parentId INTEGER NOT NULL,
+            childId INTEGER NOT NULL,
+            FOREIGN KEY(parentId) REFERENCES Comments(id),
+            FOREIGN KEY(childId) REFERENCES Comments(id)
+        )`,
+		`INSERT INTO Users VALUES (1, 'jp@jpappel.xyz', 'github', 'JP')`,
+		`INSERT INTO Pages VALUES (1, 'new', 0)`,
+		`INSERT INTO Comments(id, userId, pageId, postedTime, content) VALUES
+            (1, 1, 1, 0, 'pie'), (2, 1, 1, 1, 'apple'), (3, 1, 1, 2, 'cherry'), (4, 1, 1, 3, 'peach')`,
+		// a duplicate row for the same child is dropped in favour of the first
+		`INSERT INTO Replies(id, parentId, childId) VALUES (1, 1, 2), (2, 2, 3), (3, 2, 4), (4, 1, 4)`,
+	}
+	for _, stmt := range legacy {
+		if _, err := pdb.Db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest, err := data.LatestVersion()
+	if err != nil {
+		t.Fatal("Invalid migrations:", err)
+	}
+	if err := data.Migrate(ctx, pdb.Db, latest); err != nil {
+		t.Fatal("Failed to migrate legacy schema:", err)
+	}
+
+	rows, err := pdb.Db.Query("SELECT parentId, childId, depth FROM Replies ORDER BY childId")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	expected := [][3]int64{{1, 2, 1}, {2, 3, 2}, {2, 4, 2}}
+	var replies [][3]int64
+	for rows.Next() {
+		var reply [3]int64
+		if err := rows.Scan(&reply[0], &reply[1], &reply[2]); err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, reply)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(replies) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected replies: wanted %v got %v", expected, replies)
+	}
+
+	if _, err := pdb.Db.Exec("INSERT INTO Replies(parentId, childId, depth) VALUES (1, 3, 1)"); err == nil {
+		t.Error("Expected duplicate child to violate the unique constraint")
+	}
+}
+
+func TestMigrateDeletedBy(t *testing.T) {
+	ctx := context.Background()
+	pdb := data.PennyDB{Db: data.NewConn(fmt.Sprintf("file:%s/deleted_by.db", t.TempDir()))}
+	defer pdb.Db.Close()
+
+	if err := data.Migrate(ctx, pdb.Db, 8); err != nil {
+		t.Fatal("Failed to migrate up:", err)
+	}
+
+	for _, stmt := range []string{
+		`INSERT INTO Users(id, provider) VALUES (1, 'github')`,
+		`INSERT INTO Pages(id, url) VALUES (1, 'open')`,
+		`INSERT INTO Comments(id, userId, pageId, postedTime, content, deletedTime) VALUES
+            (1, 1, 1, 0, 'pie', 5), (2, 1, 1, 0, 'apple', 5), (3, 1, 1, 0, 'cherry', 5)`,
+		// comment 2 was deleted by a moderator, undeleted, then deleted without a record of who by
+		`INSERT INTO ModerationActions(commentId, userId, action, actionTime) VALUES
+            (1, 1, 'delete', 1), (2, 1, 'delete', 1), (2, 1, 'undelete', 2), (2, 1, 'hide', 3)`,
+	} {
+		if _, err := pdb.Db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest, err := data.LatestVersion()
+	if err != nil {
+		t.Fatal("Invalid migrations:", err)
+	}
+	if err := data.Migrate(ctx, pdb.Db, latest); err != nil {
+		t.Fatal("Failed to migrate up:", err)
+	}
+
+	expected := map[int]string{1: data.DeletedByModerator, 2: "", 3: ""}
+	for id, deletedBy := range expected {
+		var actual string
+		if err := pdb.Db.QueryRow("SELECT COALESCE(deletedBy, '') FROM Comments WHERE id = ?", id).Scan(&actual); err != nil {
+			t.Fatal(err)
+		} else if actual != deletedBy {
+			t.Errorf("Unexpected deletedBy for comment %d: wanted %q got %q", id, deletedBy, actual)
+		}
+	}
+}
+
+func TestMigratePurgedTime(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "now", MaxInt64)
+	pdb := data.PennyDB{Db: data.NewConn(fmt.Sprintf("file:%s/purged.db", t.TempDir()))}
+	defer pdb.Db.Close()
+
+	if err := data.Migrate(ctx, pdb.Db, 6); err != nil {
+		t.Fatal("Failed to migrate up:", err)
+	}
+
+	// deleting a comment used to remove its content
+	for _, stmt := range []string{
+		`INSERT INTO Users(id, provider) VALUES (1, 'github')`,
+		`INSERT INTO Pages(id, url) VALUES (1, 'open')`,
+		`INSERT INTO Comments(id, userId, pageId, postedTime, content, deletedTime) VALUES (1, 1, 1, 0, '', 5), (2, 1, 1, 0, 'pie', NULL)`,
+	} {
+		if _, err := pdb.Db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest, err := data.LatestVersion()
+	if err != nil {
+		t.Fatal("Invalid migrations:", err)
+	}
+	if err := data.Migrate(ctx, pdb.Db, latest); err != nil {
+		t.Fatal("Failed to migrate up:", err)
+	}
+
+	var purged int
+	if err := pdb.Db.QueryRow("SELECT COUNT(*) FROM Comments WHERE purgedTime = deletedTime").Scan(&purged); err != nil || purged != 1 {
+		t.Errorf("Expected only the deleted comment to be purged, got %d (%v)", purged, err)
+	}
+	if err := pdb.ModerateComments(ctx, 1, nil, data.ActionUndelete, []int64{1}, ""); !errors.Is(err, data.ErrCommentPurged) {
+		t.Errorf("Expected purged comment error, got %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_parents;
+DROP TABLE IF EXISTS Replies;
+DROP INDEX IF EXISTS idx_postedTime;
+DROP INDEX IF EXISTS idx_users;
+DROP TABLE IF EXISTS Comments;
+DROP TABLE IF EXISTS Pages;
+DROP TABLE IF EXISTS Users;
@@ -0,0 +1,62 @@
+CREATE TABLE IF NOT EXISTS Users(
+    id INTEGER PRIMARY KEY,
+    email TEXT,
+    provider TEXT NOT NULL,
+    name TEXT,
+    UNIQUE(email, provider)
+);
+
+CREATE TABLE IF NOT EXISTS Pages(
+    id INTEGER PRIMARY KEY,
+    url TEXT UNIQUE NOT NULL,
+    commentsOpenTime INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS Comments(
+    id INTEGER PRIMARY KEY,
+    userId INTEGER NOT NULL,
+    pageId INTEGER NOT NULL,
+    hiddenTime INTEGER,
+    deletedTime INTEGER,
+    postedTime INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    FOREIGN KEY(userId) REFERENCES Users(id),
+    FOREIGN KEY(pageId) REFERENCES Pages(id)
+);
+CREATE INDEX IF NOT EXISTS idx_users ON Comments(userId);
+CREATE INDEX IF NOT EXISTS idx_postedTime ON Comments(postedTime);
+
+-- Replies tracks the parent of every reply and its depth in the thread.
+-- Root comments have no row and an implicit depth of 0.
+-- Databases created before migrations already have a Replies table without
+-- depths or a unique child, so replies are always copied into a rebuilt table.
+CREATE TABLE IF NOT EXISTS Replies(
+    id INTEGER PRIMARY KEY,
+    parentId INTEGER NOT NULL,
+    childId INTEGER NOT NULL
+);
+CREATE TABLE RepliesRebuilt(
+    id INTEGER PRIMARY KEY,
+    parentId INTEGER NOT NULL,
+    childId INTEGER UNIQUE NOT NULL,
+    depth INTEGER NOT NULL CHECK(depth > 0),
+    FOREIGN KEY(parentId) REFERENCES Comments(id),
+    FOREIGN KEY(childId) REFERENCES Comments(id)
+);
+-- keep the first parent recorded for a child, replies unreachable from a root are dropped
+INSERT INTO RepliesRebuilt(id, parentId, childId, depth)
+WITH RECURSIVE
+    FirstReplies(id, parentId, childId) AS (
+        SELECT MIN(id), parentId, childId FROM Replies GROUP BY childId
+    ),
+    Thread(id, parentId, childId, depth) AS (
+        SELECT id, parentId, childId, 1 FROM FirstReplies
+        WHERE parentId NOT IN (SELECT childId FROM FirstReplies)
+        UNION ALL
+        SELECT r.id, r.parentId, r.childId, t.depth + 1
+        FROM FirstReplies r INNER JOIN Thread t ON r.parentId = t.childId
+    )
+SELECT id, parentId, childId, depth FROM Thread;
+DROP TABLE Replies;
+ALTER TABLE RepliesRebuilt RENAME TO Replies;
+CREATE INDEX IF NOT EXISTS idx_parents ON Replies(parentId);
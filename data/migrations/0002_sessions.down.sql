@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_sessionExpires;
+DROP TABLE IF EXISTS Sessions;
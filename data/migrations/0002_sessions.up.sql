@@ -0,0 +1,11 @@
+-- tokens are stored as hashes so a leaked database cannot be used to sign in
+CREATE TABLE IF NOT EXISTS Sessions(
+    id INTEGER PRIMARY KEY,
+    tokenHash TEXT UNIQUE NOT NULL,
+    userId INTEGER NOT NULL,
+    createdTime INTEGER NOT NULL,
+    rotatedTime INTEGER NOT NULL,
+    expiresTime INTEGER NOT NULL,
+    FOREIGN KEY(userId) REFERENCES Users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_sessionExpires ON Sessions(expiresTime);
@@ -0,0 +1 @@
+ALTER TABLE Comments DROP COLUMN html;
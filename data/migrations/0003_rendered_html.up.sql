@@ -0,0 +1,2 @@
+-- content rendered through the filters and markdown when posted, NULL until rendered
+ALTER TABLE Comments ADD COLUMN html TEXT;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_queuedTime;
+DROP TABLE IF EXISTS ModerationQueue;
@@ -0,0 +1,9 @@
+-- comments waiting for a moderator, held comments are also hidden until approved
+CREATE TABLE IF NOT EXISTS ModerationQueue(
+    id INTEGER PRIMARY KEY,
+    commentId INTEGER UNIQUE NOT NULL,
+    reason TEXT NOT NULL,
+    queuedTime INTEGER NOT NULL,
+    FOREIGN KEY(commentId) REFERENCES Comments(id)
+);
+CREATE INDEX IF NOT EXISTS idx_queuedTime ON ModerationQueue(queuedTime);
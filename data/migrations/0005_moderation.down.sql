@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_actionComment;
+DROP INDEX IF EXISTS idx_actionTime;
+DROP TABLE IF EXISTS ModerationActions;
+DROP TABLE IF EXISTS Reports;
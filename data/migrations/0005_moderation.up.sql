@@ -0,0 +1,25 @@
+-- comments flagged by readers, each user can report a comment once
+CREATE TABLE IF NOT EXISTS Reports(
+    id INTEGER PRIMARY KEY,
+    commentId INTEGER NOT NULL,
+    userId INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    reportedTime INTEGER NOT NULL,
+    UNIQUE(commentId, userId),
+    FOREIGN KEY(commentId) REFERENCES Comments(id),
+    FOREIGN KEY(userId) REFERENCES Users(id)
+);
+
+-- audit trail of every moderation action and who took it
+CREATE TABLE IF NOT EXISTS ModerationActions(
+    id INTEGER PRIMARY KEY,
+    commentId INTEGER NOT NULL,
+    userId INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    actionTime INTEGER NOT NULL,
+    FOREIGN KEY(commentId) REFERENCES Comments(id),
+    FOREIGN KEY(userId) REFERENCES Users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_actionTime ON ModerationActions(actionTime);
+CREATE INDEX IF NOT EXISTS idx_actionComment ON ModerationActions(commentId);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ModeratorPages;
+ALTER TABLE Users DROP COLUMN role;
@@ -0,0 +1,11 @@
+-- one of user, moderator, admin or banned
+ALTER TABLE Users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+
+-- url prefixes of the pages each moderator may moderate
+CREATE TABLE IF NOT EXISTS ModeratorPages(
+    id INTEGER PRIMARY KEY,
+    userId INTEGER NOT NULL,
+    prefix TEXT NOT NULL,
+    UNIQUE(userId, prefix),
+    FOREIGN KEY(userId) REFERENCES Users(id)
+);
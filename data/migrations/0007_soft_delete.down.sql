@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_deletedTime;
+ALTER TABLE Comments DROP COLUMN purgedTime;
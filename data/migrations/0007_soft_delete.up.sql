@@ -0,0 +1,6 @@
+-- when a deleted comment's content was removed, it can no longer be restored
+ALTER TABLE Comments ADD COLUMN purgedTime INTEGER;
+CREATE INDEX IF NOT EXISTS idx_deletedTime ON Comments(deletedTime);
+
+-- comments deleted before soft deletion already had their content removed
+UPDATE Comments SET purgedTime = deletedTime WHERE deletedTime IS NOT NULL;
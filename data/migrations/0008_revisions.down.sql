@@ -0,0 +1,2 @@
+ALTER TABLE Comments DROP COLUMN editedTime;
+DROP TABLE IF EXISTS CommentRevisions;
@@ -0,0 +1,12 @@
+-- every prior body of an edited comment, with when it was written
+CREATE TABLE IF NOT EXISTS CommentRevisions(
+    id INTEGER PRIMARY KEY,
+    commentId INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    html TEXT,
+    writtenTime INTEGER NOT NULL,
+    FOREIGN KEY(commentId) REFERENCES Comments(id)
+);
+CREATE INDEX IF NOT EXISTS idx_revisionComment ON CommentRevisions(commentId);
+
+ALTER TABLE Comments ADD COLUMN editedTime INTEGER;
@@ -0,0 +1,10 @@
+-- who deleted a comment, author or moderator
+ALTER TABLE Comments ADD COLUMN deletedBy TEXT;
+
+-- a comment was deleted by a moderator if their latest delete or undelete was a delete,
+-- earlier deletions have no record of who made them and are left unknown
+UPDATE Comments SET deletedBy = 'moderator'
+WHERE deletedTime IS NOT NULL AND (
+    SELECT action FROM ModerationActions
+    WHERE commentId = Comments.id AND action IN ('delete', 'undelete')
+    ORDER BY ModerationActions.id DESC LIMIT 1) = 'delete';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_sessionPrevious;
+ALTER TABLE Sessions DROP COLUMN previousExpiresTime;
+ALTER TABLE Sessions DROP COLUMN previousTokenHash;
@@ -0,0 +1,5 @@
+-- the token a session had before it was last rotated, accepted until previousExpiresTime
+-- so requests sent in parallel with the rotation are not signed out
+ALTER TABLE Sessions ADD COLUMN previousTokenHash TEXT;
+ALTER TABLE Sessions ADD COLUMN previousExpiresTime INTEGER;
+CREATE INDEX IF NOT EXISTS idx_sessionPrevious ON Sessions(previousTokenHash);
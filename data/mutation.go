@@ -3,42 +3,76 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
 	"time"
 )
 
-func (p PennyDB) PostComment(ctx context.Context, page string, user string, comment string, parentId *int64) (int, error) {
+// Post a comment with its source and rendered html
+func (p PennyDB) PostComment(ctx context.Context, page string, userId int64, comment string, html string, parentId *int64) (int, error) {
+	return p.postComment(ctx, page, userId, comment, html, parentId, "")
+}
+
+// Post a comment hidden and queued for moderation with the reason it was held
+func (p PennyDB) PostHeldComment(ctx context.Context, page string, userId int64, comment string, html string, parentId *int64, reason string) (int, error) {
+	return p.postComment(ctx, page, userId, comment, html, parentId, reason)
+}
+
+// Post a comment, holding it when heldReason is not empty
+func (p PennyDB) postComment(ctx context.Context, page string, userId int64, comment string, html string, parentId *int64, heldReason string) (int, error) {
 	tx, err := p.Db.BeginTx(ctx, nil)
 	if err != nil {
 		panic(err)
 	}
 
-	var userId int64
-	err = tx.QueryRowContext(ctx, "SELECT id FROM Users WHERE email = ?", user).Scan(&userId)
+	err = tx.QueryRowContext(ctx, "SELECT id FROM Users WHERE id = ?", userId).Scan(&userId)
 	if err == sql.ErrNoRows {
 		tx.Rollback()
-        // TODO: gracefully handle no existing user
-		return -1, err
+		return -1, ErrNoUser
 	} else if err != nil {
 		tx.Rollback()
 		panic(err)
 	}
 
+	now := time.Now().UTC().Unix()
+
 	var pageId int64
-	err = tx.QueryRowContext(ctx, "SELECT id FROM Pages WHERE url = ?", page).Scan(&pageId)
+	var openTime sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT id, commentsOpenTime FROM Pages WHERE url = ?", page).Scan(&pageId, &openTime)
 	if err == sql.ErrNoRows {
-        // TODO: gracefully handle no existing page
 		tx.Rollback()
-		return -1, err
+		return -1, ErrNoPage
 	} else if err != nil {
 		tx.Rollback()
 		panic(err)
 	}
 
-	now := time.Now().UTC().Unix()
+	if !openTime.Valid || openTime.Int64 <= now {
+		tx.Rollback()
+		return -1, ErrPageClosed
+	}
+
+	var depth int64
+	if parentId != nil {
+		var parentPageId int64
+		err = tx.QueryRowContext(ctx, `
+        SELECT pageId, COALESCE(depth, 0)
+        FROM Comments LEFT JOIN Replies ON Comments.id = Replies.childId
+        WHERE Comments.id = ?`, *parentId).Scan(&parentPageId, &depth)
+		if err == sql.ErrNoRows || (err == nil && parentPageId != pageId) {
+			tx.Rollback()
+			return -1, ErrNoParent
+		} else if err != nil {
+			tx.Rollback()
+			return -1, err
+		}
+	}
+
 	result, err := tx.ExecContext(ctx, `INSERT INTO Comments
-    (userId, pageId, postedTime, content)
-    VALUES(?,?,?,?)
-    `, userId, pageId, now, comment)
+    (userId, pageId, postedTime, content, html)
+    VALUES(?,?,?,?,?)
+    `, userId, pageId, now, comment, html)
 	if err != nil {
 		tx.Rollback()
 		panic(err)
@@ -50,51 +84,496 @@ func (p PennyDB) PostComment(ctx context.Context, page string, user string, comm
 		return -1, err
 	}
 
-	if parentId == nil {
-		_, err = tx.ExecContext(ctx, "INSERT INTO Relations(childId, depth) VALUES (?, 0)", id)
-	} else {
-		var parentDepth int64
-		tx.QueryRowContext(ctx, "SELECT depth FROM Relations WHERE childId = ?", *parentId).Scan(&parentDepth)
-		_, err = tx.ExecContext(ctx, "INSERT INTO Relations(parentId, childId, depth) (?, ?, ?)", *parentId, id, parentDepth+1)
+	if parentId != nil {
+		_, err = tx.ExecContext(ctx, "INSERT INTO Replies(parentId, childId, depth) VALUES (?, ?, ?)", *parentId, id, depth+1)
+		if err != nil {
+			tx.Rollback()
+			return -1, err
+		}
 	}
-	if err != nil {
-		tx.Rollback()
-		return -1, err
+
+	if heldReason != "" {
+		if err := holdComment(ctx, tx, id, heldReason, now); err != nil {
+			tx.Rollback()
+			return -1, err
+		}
 	}
 
 	tx.Commit()
 	return int(id), nil
 }
 
-func (p PennyDB) HideComment(ctx context.Context, commentId int64) error {
+// Replace the content of a comment that has not been deleted, keeping its previous content as a revision
+//
+// Nothing changes if the content is the same.
+// A non empty heldReason hides the comment and queues it for moderation in the same transaction.
+func (p PennyDB) EditComment(ctx context.Context, commentId int64, content string, html string, heldReason string) error {
 	tx, err := p.Db.BeginTx(ctx, nil)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	var oldContent string
+	var oldHTML sql.NullString
+	var writtenTime int64
+	err = tx.QueryRowContext(ctx, `
+    SELECT content, html, COALESCE(editedTime, postedTime) FROM Comments
+    WHERE id = ? AND deletedTime IS NULL`, commentId).Scan(&oldContent, &oldHTML, &writtenTime)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return ErrNoComment
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	} else if oldContent == content {
+		tx.Rollback()
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+    INSERT INTO CommentRevisions(commentId, content, html, writtenTime) VALUES (?,?,?,?)`,
+		commentId, oldContent, oldHTML, writtenTime)
+	if err != nil {
+		tx.Rollback()
+		return err
 	}
 
 	now := time.Now().UTC().Unix()
-	if _, err = tx.ExecContext(ctx, "UPDATE Comments SET hiddenTime = ? WHERE id = ?", now, commentId); err != nil {
+	_, err = tx.ExecContext(ctx,
+		"UPDATE Comments SET content = ?, html = ?, editedTime = ? WHERE id = ?",
+		content, html, now, commentId)
+	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	tx.Commit()
+	if heldReason != "" {
+		if err := holdComment(ctx, tx, commentId, heldReason, now); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func holdComment(ctx context.Context, tx *sql.Tx, commentId int64, reason string, now int64) error {
+	result, err := tx.ExecContext(ctx, "UPDATE Comments SET hiddenTime = ? WHERE id = ? AND deletedTime IS NULL", now, commentId)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNoComment
+	}
+
+	_, err = tx.ExecContext(ctx, `
+    INSERT INTO ModerationQueue(commentId, reason, queuedTime) VALUES (?, ?, ?)
+    ON CONFLICT(commentId) DO UPDATE SET reason = excluded.reason, queuedTime = excluded.queuedTime`,
+		commentId, reason, now)
+	return err
+}
+
+// Delete a comment for its author, its content is kept for moderators until it is purged
+//
+// Deleting a comment that was already deleted changes nothing.
+func (p PennyDB) DeleteComment(ctx context.Context, commentId int64, authorId int64) error {
+	tx, err := p.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	var userId int64
+	var deleted bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT userId, deletedTime IS NOT NULL FROM Comments WHERE id = ?", commentId).Scan(&userId, &deleted)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return ErrNoComment
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	} else if userId != authorId {
+		tx.Rollback()
+		return ErrNotAuthor
+	} else if deleted {
+		tx.Rollback()
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ModerationQueue WHERE commentId = ?", commentId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	now := time.Now().UTC().Unix()
+	_, err = tx.ExecContext(ctx,
+		"UPDATE Comments SET deletedTime = ?, deletedBy = ? WHERE id = ?", now, DeletedByAuthor, commentId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Regenerate the html of every comment that has not been deleted, returning how many were updated
+//
+// Comments that fail to render keep their previous html, their errors are returned together.
+func (p PennyDB) RerenderComments(ctx context.Context, render func(content string) (string, error)) (int, error) {
+	rows, err := p.Db.QueryContext(ctx, "SELECT id, content FROM Comments WHERE deletedTime IS NULL ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+
+	type source struct {
+		id      int64
+		content string
+	}
+	var sources []source
+	for rows.Next() {
+		var src source
+		if err := rows.Scan(&src.id, &src.content); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		sources = append(sources, src)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := p.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	updated := 0
+	for _, src := range sources {
+		html, err := render(src.content)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("comment %d: %w", src.id, err))
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE Comments SET html = ? WHERE id = ?", html, src.id); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		updated++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return updated, errors.Join(errs...)
+}
+
+// Create or update a user signing in with a provider, returning their id
+func (p PennyDB) UpsertUser(ctx context.Context, email string, provider string, name string) (int64, error) {
+	var id int64
+	err := p.Db.QueryRowContext(ctx, `
+    INSERT INTO Users(email, provider, name)
+    VALUES (?,?,?)
+    ON CONFLICT(email, provider) DO UPDATE SET name = excluded.name
+    RETURNING id`, email, provider, name).Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+
+	return id, nil
+}
+
+func (p PennyDB) CreateSession(ctx context.Context, tokenHash string, userId int64, expires time.Time) error {
+	now := time.Now().UTC().Unix()
+	_, err := p.Db.ExecContext(ctx, `
+    INSERT INTO Sessions(tokenHash, userId, createdTime, rotatedTime, expiresTime)
+    VALUES (?,?,?,?,?)`, tokenHash, userId, now, now, expires.UTC().Unix())
+	return err
+}
+
+// Replace the token of a session, keeping its expiry
+//
+// The old token is still accepted until graceUntil.
+// Returns ErrNoSession if oldHash is not the current token, such as when it was already rotated.
+func (p PennyDB) RotateSession(ctx context.Context, oldHash string, newHash string, graceUntil time.Time) error {
+	now := time.Now().UTC().Unix()
+	result, err := p.Db.ExecContext(ctx, `
+    UPDATE Sessions SET tokenHash = ?, rotatedTime = ?, previousTokenHash = tokenHash, previousExpiresTime = ?
+    WHERE tokenHash = ?`,
+		newHash, now, graceUntil.UTC().Unix(), oldHash)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNoSession
+	}
+
 	return nil
 }
 
-func (p PennyDB) DeleteComment(ctx context.Context, commentId int64) error {
+// Remove the session with a token, current or from before its last rotation
+func (p PennyDB) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := p.Db.ExecContext(ctx, "DELETE FROM Sessions WHERE tokenHash = ? OR previousTokenHash = ?", tokenHash, tokenHash)
+	return err
+}
+
+// Remove sessions that expired before now, returning how many were removed
+func (p PennyDB) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	result, err := p.Db.ExecContext(ctx, "DELETE FROM Sessions WHERE expiresTime <= ?", now.UTC().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Apply a moderation action to comments, recording who took it in the audit trail
+//
+// Comments must be on pages starting with one of pagePrefixes unless it is nil.
+// Either every comment is changed or none are.
+func (p PennyDB) ModerateComments(ctx context.Context, moderatorId int64, pagePrefixes []string, action string, commentIds []int64, reason string) error {
+	if !slices.Contains(ModerationActions, action) {
+		return ErrUnknownAction
+	}
+
 	tx, err := p.Db.BeginTx(ctx, nil)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	now := time.Now().UTC().Unix()
-	_, err = tx.ExecContext(ctx, "UPDATE Comments SET deletedTime = ?, content = ? WHERE id = ?", now, "", commentId)
+	for _, id := range commentIds {
+		var pageUrl string
+		err := tx.QueryRowContext(ctx, `
+        SELECT url FROM Comments JOIN Pages ON Comments.pageId = Pages.id
+        WHERE Comments.id = ?`, id).Scan(&pageUrl)
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			return fmt.Errorf("%w %d", ErrNoComment, id)
+		} else if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if pagePrefixes != nil && !hasPrefix(pageUrl, pagePrefixes) {
+			tx.Rollback()
+			return fmt.Errorf("%w %s", ErrNotPermitted, pageUrl)
+		}
+
+		if err := moderateComment(ctx, tx, action, id, now); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+        INSERT INTO ModerationActions(commentId, userId, action, reason, actionTime)
+        VALUES (?,?,?,?,?)`, id, moderatorId, action, reason, now)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func moderateComment(ctx context.Context, tx *sql.Tx, action string, commentId int64, now int64) error {
+	var err error
+	switch action {
+	case ActionHide:
+		_, err = tx.ExecContext(ctx, "UPDATE Comments SET hiddenTime = ? WHERE id = ? AND hiddenTime IS NULL", now, commentId)
+	case ActionUnhide:
+		_, err = tx.ExecContext(ctx, "UPDATE Comments SET hiddenTime = NULL WHERE id = ?", commentId)
+	case ActionDelete:
+		if _, err = tx.ExecContext(ctx, "DELETE FROM ModerationQueue WHERE commentId = ?", commentId); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"UPDATE Comments SET deletedTime = ?, deletedBy = ? WHERE id = ? AND deletedTime IS NULL",
+			now, DeletedByModerator, commentId)
+	case ActionUndelete:
+		var purged bool
+		err = tx.QueryRowContext(ctx, "SELECT purgedTime IS NOT NULL FROM Comments WHERE id = ?", commentId).Scan(&purged)
+		if err != nil {
+			return err
+		} else if purged {
+			return fmt.Errorf("%w %d", ErrCommentPurged, commentId)
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE Comments SET deletedTime = NULL, deletedBy = NULL WHERE id = ?", commentId)
+	case ActionApprove:
+		// approving releases a held comment and dismisses its reports
+		if _, err = tx.ExecContext(ctx, "UPDATE Comments SET hiddenTime = NULL WHERE id = ?", commentId); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, "DELETE FROM ModerationQueue WHERE commentId = ?", commentId); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM Reports WHERE commentId = ?", commentId)
+	}
+	return err
+}
+
+// Remove the content and revisions of comments deleted before a time, returning how many were purged
+//
+// Purged comments can no longer be undeleted.
+func (p PennyDB) PurgeDeletedComments(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := p.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+    DELETE FROM CommentRevisions WHERE commentId IN (
+        SELECT id FROM Comments WHERE deletedTime <= ? AND purgedTime IS NULL)`, before.UTC().Unix())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+    UPDATE Comments SET content = '', html = NULL, purgedTime = ?
+    WHERE deletedTime <= ? AND purgedTime IS NULL`, time.Now().UTC().Unix(), before.UTC().Unix())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
 	if err != nil {
 		tx.Rollback()
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// Report a comment to moderators, reporting the same comment again updates the reason
+func (p PennyDB) ReportComment(ctx context.Context, commentId int64, userId int64, reason string) error {
+	now := time.Now().UTC().Unix()
+	result, err := p.Db.ExecContext(ctx, `
+    INSERT INTO Reports(commentId, userId, reason, reportedTime)
+    SELECT id, ?, ?, ? FROM Comments WHERE id = ? AND deletedTime IS NULL
+    ON CONFLICT(commentId, userId) DO UPDATE SET reason = excluded.reason, reportedTime = excluded.reportedTime`,
+		userId, reason, now, commentId)
+	if err != nil {
 		return err
 	}
 
-	tx.Commit()
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNoComment
+	}
+
+	return nil
+}
+
+// Change a user's role, replacing the pages they may moderate
+//
+// Pages are only kept for moderators.
+func (p PennyDB) SetRole(ctx context.Context, userId int64, role Role, pages []string) error {
+	if !slices.Contains(Roles, role) {
+		return ErrUnknownRole
+	}
+
+	tx, err := p.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, "UPDATE Users SET role = ? WHERE id = ?", role, userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		tx.Rollback()
+		return err
+	} else if n == 0 {
+		tx.Rollback()
+		return ErrNoUser
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ModeratorPages WHERE userId = ?", userId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if role == RoleModerator {
+		for _, prefix := range pages {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO ModeratorPages(userId, prefix) VALUES (?,?) ON CONFLICT DO NOTHING", userId, prefix)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Make the users with these emails administrators, by provider, returning how many were changed
+//
+// Emails are matched case insensitively and users who have not signed in yet are skipped.
+func (p PennyDB) GrantAdmins(ctx context.Context, admins map[string][]string) (int64, error) {
+	var granted int64
+	for provider, emails := range admins {
+		for _, email := range emails {
+			result, err := p.Db.ExecContext(ctx, `
+            UPDATE Users SET role = ?
+            WHERE provider = ? COLLATE NOCASE AND email = ? COLLATE NOCASE AND role != ?`,
+				RoleAdmin, provider, email, RoleAdmin)
+			if err != nil {
+				return granted, err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return granted, err
+			}
+			granted += n
+		}
+	}
+
+	if granted > 0 {
+		_, err := p.Db.ExecContext(ctx,
+			"DELETE FROM ModeratorPages WHERE userId IN (SELECT id FROM Users WHERE role != ?)", RoleModerator)
+		if err != nil {
+			return granted, err
+		}
+	}
+
+	return granted, nil
+}
+
+// Open comments on a page until a time, creating the page if needed
+func (p PennyDB) OpenPage(ctx context.Context, pageUrl string, until time.Time) error {
+	_, err := p.Db.ExecContext(ctx, `
+    INSERT INTO Pages(url, commentsOpenTime) VALUES (?,?)
+    ON CONFLICT(url) DO UPDATE SET commentsOpenTime = excluded.commentsOpenTime`,
+		pageUrl, until.UTC().Unix())
+	return err
+}
+
+// Close comments on a page
+func (p PennyDB) ClosePage(ctx context.Context, pageUrl string) error {
+	result, err := p.Db.ExecContext(ctx, "UPDATE Pages SET commentsOpenTime = NULL WHERE url = ?", pageUrl)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNoPage
+	}
+
 	return nil
 }
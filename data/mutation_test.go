@@ -1,35 +1,670 @@
 package data_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
+
+	"github.com/jpappel/penny/data"
 )
 
+type post struct {
+	userId   int64
+	content  string
+	parentId int64 // 0 for root comments
+}
+
+// users and an open page without any comments
+func openPage(connStr string) data.PennyDB {
+	pdb := data.New(connStr)
+
+	tx, err := pdb.Db.Begin()
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = tx.Exec(`
+    INSERT INTO Users(email, provider, name)
+    VALUES (?,?,?), (?,?,?), (?,?,?)`,
+		"a@z.com", "github", "A Z",
+		"b@y.org", "google", "B Y",
+		"c@x.net", "twitter", "C X")
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = tx.Exec("INSERT INTO Pages(url, commentsOpenTime) VALUES (?,?), (?,NULL)", "open", MaxInt64, "closed")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+
+	return pdb
+}
+
+// post comments in order to a page then read back that page
+func postComments(pageUrl string, posts []post) func(data.PennyDB) (*data.Page, error) {
+	return func(p data.PennyDB) (*data.Page, error) {
+		ctx := context.WithValue(context.Background(), "now", MaxInt64)
+		for _, post := range posts {
+			var parentId *int64
+			if post.parentId != 0 {
+				parentId = &post.parentId
+			}
+			if _, err := p.PostComment(ctx, pageUrl, post.userId, post.content, "", parentId); err != nil {
+				return nil, err
+			}
+		}
+
+		page, err := p.GetPageComments(ctx, pageUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		// posted times depend on when the test is run
+		for i := range page.Comments {
+			page.Comments[i].Posted = time.Unix(0, 0)
+		}
+
+		return page, nil
+	}
+}
+
 func TestPostComment(t *testing.T) {
+	openPageUpdated := data.PageInfo{Url: "open", UpdateTime: time.Unix(MaxInt64, 0)}
 	testCases := []CommentsTestCase{
-		// TODO: test invalid page
-		// TODO: test invalid user
-		// TODO: test no parent
-		// TODO: test parent
+		{"InvalidPage",
+			nil,
+			data.ErrNoPage,
+			openPage,
+			postComments("I do not exist", []post{{1, "pie", 0}}),
+		},
+		{"ClosedPage",
+			nil,
+			data.ErrPageClosed,
+			openPage,
+			postComments("closed", []post{{1, "pie", 0}}),
+		},
+		{"InvalidUser",
+			nil,
+			data.ErrNoUser,
+			openPage,
+			postComments("open", []post{{100, "pie", 0}}),
+		},
+		{"InvalidParent",
+			nil,
+			data.ErrNoParent,
+			openPage,
+			postComments("open", []post{{1, "pie", 0}, {2, "crust", 100}}),
+		},
+		{"NoParent",
+			&data.Page{
+				PageInfo: openPageUpdated,
+				Comments: []data.Comment{{Id: 1, Content: "pie", Posted: time.Unix(0, 0)}},
+			},
+			nil,
+			openPage,
+			postComments("open", []post{{1, "pie", 0}}),
+		},
+		{"NestedCommentChain",
+			&data.Page{
+				PageInfo: openPageUpdated,
+				Comments: []data.Comment{
+					{Id: 1, Content: "cobbler", Posted: time.Unix(0, 0), Replies: []int{2}},
+					{Id: 2, Content: "with", Posted: time.Unix(0, 0), Replies: []int{3}, Depth: 1},
+					{Id: 3, Content: "icecream", Posted: time.Unix(0, 0), Depth: 2},
+				},
+			},
+			nil,
+			openPage,
+			postComments("open", []post{
+				{1, "cobbler", 0},
+				{2, "with", 1},
+				{1, "icecream", 2},
+			}),
+		},
+		{"CommentForest",
+			&data.Page{
+				PageInfo: openPageUpdated,
+				Comments: []data.Comment{
+					{Id: 1, Content: "first", Posted: time.Unix(0, 0), Replies: []int{4, 5}},
+					{Id: 2, Content: "second", Posted: time.Unix(0, 0), Replies: []int{6}},
+					{Id: 3, Content: "last", Posted: time.Unix(0, 0), Replies: []int{8}},
+					{Id: 4, Content: "letter", Posted: time.Unix(0, 0), Replies: []int{7}, Depth: 1},
+					{Id: 5, Content: "animal", Posted: time.Unix(0, 0), Depth: 1},
+					{Id: 6, Content: "ammendment", Posted: time.Unix(0, 0), Depth: 1},
+					{Id: 7, Content: "is an inverted bull", Posted: time.Unix(0, 0), Depth: 2},
+					{Id: 8, Content: "christmas", Posted: time.Unix(0, 0), Depth: 1},
+				},
+			},
+			nil,
+			openPage,
+			postComments("open", []post{
+				{1, "first", 0},
+				{2, "second", 0},
+				{3, "last", 0},
+				{2, "letter", 1},
+				{3, "animal", 1},
+				{1, "ammendment", 2},
+				{3, "is an inverted bull", 4},
+				{2, "christmas", 3},
+			}),
+		},
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, testCase.Test)
 	}
 }
 
+func TestPostHeldComment(t *testing.T) {
+	pdb := openPage(fmt.Sprintf("file:%s/held.db", t.TempDir()))
+	ctx := context.WithValue(context.Background(), "now", MaxInt64)
+
+	if _, err := pdb.PostComment(ctx, "open", 1, "pie", "<p>pie</p>", nil); err != nil {
+		t.Fatal("Failed to post comment:", err)
+	}
+	parentId := int64(1)
+	id, err := pdb.PostHeldComment(ctx, "open", 2, "spam", "<p>spam</p>", &parentId, "Comment contains a banned word")
+	if err != nil {
+		t.Fatal("Failed to post held comment:", err)
+	}
+
+	comment, err := pdb.GetCommentById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !comment.Held || !comment.Hidden || comment.Content != "" || comment.Rendered != "" {
+		t.Errorf("Expected held comment to be hidden without content, got %+v", comment)
+	}
+
+	page, err := pdb.GetPageComments(ctx, "open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Comments) != 2 || page.Comments[0].Held || !page.Comments[1].Held {
+		t.Errorf("Expected only the reply to be held, got %+v", page.Comments)
+	}
+
+	var reason string
+	if err := pdb.Db.QueryRow("SELECT reason FROM ModerationQueue WHERE commentId = ?", id).Scan(&reason); err != nil {
+		t.Fatal("Held comment was not queued:", err)
+	} else if reason != "Comment contains a banned word" {
+		t.Errorf("Unexpected reason: %q", reason)
+	}
+
+	if _, err := pdb.PostHeldComment(ctx, "closed", 2, "spam", "", nil, "held"); err != data.ErrPageClosed {
+		t.Errorf("Expected closed page error, got %v", err)
+	}
+}
+
 func TestDeleteComment(t *testing.T) {
-	testCases := []CommentsTestCase{
-        // TODO: test no comment
-        // TODO: test already deleted comment
-        // TODO: test normal deletion
-    }
-	for _, testCase := range testCases {
-		t.Run(testCase.name, testCase.Test)
+	pdb := openPage(fmt.Sprintf("file:%s/delete.db", t.TempDir()))
+	ctx := context.WithValue(context.Background(), "now", MaxInt64)
+
+	for _, content := range []string{"pie", "cobbler"} {
+		if _, err := pdb.PostHeldComment(ctx, "open", 1, content, "", nil, "needs review"); err != nil {
+			t.Fatal("Failed to post comment:", err)
+		}
+	}
+	if err := pdb.ModerateComments(ctx, 2, nil, data.ActionHide, []int64{1}, "spoilers"); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name      string
+		commentId int64
+		authorId  int64
+		err       error
+	}{
+		{"No Comment", 100, 1, data.ErrNoComment},
+		{"Not Author", 1, 2, data.ErrNotAuthor},
+		{"Author", 1, 1, nil},
+		{"Already Deleted", 1, 1, nil},
+	}
+	for _, step := range steps {
+		if err := pdb.DeleteComment(ctx, step.commentId, step.authorId); !errors.Is(err, step.err) {
+			t.Errorf("%s: wanted error %v got %v", step.name, step.err, err)
+		}
+	}
+	if err := pdb.ModerateComments(ctx, 2, nil, data.ActionDelete, []int64{2}, "off topic"); err != nil {
+		t.Fatal(err)
+	}
+
+	comments, err := pdb.GetModerationComments(ctx, data.ModerationFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]struct{ deletedBy, reason string }{
+		1: {data.DeletedByAuthor, ""},
+		2: {data.DeletedByModerator, "off topic"},
+	}
+	for _, c := range comments {
+		e := expected[c.Id]
+		if !c.Deleted || c.Held || c.DeletedBy != e.deletedBy || c.Reason != e.reason {
+			t.Errorf("Unexpected deleted comment %d: %+v", c.Id, c)
+		}
+	}
+
+	if err := pdb.ModerateComments(ctx, 2, nil, data.ActionUndelete, []int64{2}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if comment, err := pdb.GetCommentById(ctx, 2); err != nil || comment.Deleted || comment.DeletedBy != "" {
+		t.Errorf("Expected undeleted comment, got %+v %v", comment, err)
 	}
 }
 
-func TestHideComment(t *testing.T) {
-	testCases := []CommentsTestCase{}
-	for _, testCase := range testCases {
-		t.Run(testCase.name, testCase.Test)
+func TestUpsertUser(t *testing.T) {
+	pdb := openPage(fmt.Sprintf("file:%s/upsert.db", t.TempDir()))
+	ctx := context.Background()
+
+	testCases := []struct {
+		email, provider, name string
+		expectedId            int64
+	}{
+		{"a@z.com", "github", "A Z", 1},
+		{"a@z.com", "google", "A Z", 4},
+		{"b@y.org", "google", "Bee Y", 2},
+		{"d@w.com", "github", "D W", 5},
+	}
+
+	for _, tc := range testCases {
+		id, err := pdb.UpsertUser(ctx, tc.email, tc.provider, tc.name)
+		if err != nil {
+			t.Fatal("Failed to upsert user:", err)
+		}
+		if id != tc.expectedId {
+			t.Errorf("Unexpected id for %s (%s): wanted %d got %d\n", tc.email, tc.provider, tc.expectedId, id)
+		}
+	}
+
+	var name string
+	if err := pdb.Db.QueryRow("SELECT name FROM Users WHERE id = 2").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Bee Y" {
+		t.Errorf("Name was not updated: wanted `Bee Y` got `%s`\n", name)
+	}
+}
+
+func TestRerenderComments(t *testing.T) {
+	pdb := openPage(fmt.Sprintf("file:%s/rerender.db", t.TempDir()))
+	ctx := context.WithValue(context.Background(), "now", MaxInt64)
+
+	for _, content := range []string{"pie", "cobbler", "crumble"} {
+		if _, err := pdb.PostComment(ctx, "open", 1, content, "<p>old</p>", nil); err != nil {
+			t.Fatal("Failed to post comment:", err)
+		}
+	}
+	if err := pdb.DeleteComment(ctx, 3, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	errPie := errors.New("no pie")
+	n, err := pdb.RerenderComments(ctx, func(content string) (string, error) {
+		if content == "pie" {
+			return "", errPie
+		}
+		return "<b>" + content + "</b>", nil
+	})
+	if n != 1 {
+		t.Errorf("Expected 1 comment to be rendered, got %d", n)
+	}
+	if !errors.Is(err, errPie) {
+		t.Errorf("Expected render error to be returned, got %v", err)
+	}
+
+	page, err := pdb.GetPageComments(ctx, "open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range page.Comments {
+		expected := map[int]string{1: "<p>old</p>", 2: "<b>cobbler</b>", 3: ""}[c.Id]
+		if c.Rendered != expected {
+			t.Errorf("Unexpected html for comment %d: wanted %q got %q", c.Id, expected, c.Rendered)
+		}
+	}
+}
+
+func TestModerateComments(t *testing.T) {
+	pdb := openPage(fmt.Sprintf("file:%s/moderate.db", t.TempDir()))
+	ctx := context.WithValue(context.Background(), "now", MaxInt64)
+
+	for _, content := range []string{"pie", "cobbler", "crumble"} {
+		if _, err := pdb.PostComment(ctx, "open", 1, content, "", nil); err != nil {
+			t.Fatal("Failed to post comment:", err)
+		}
+	}
+	if _, err := pdb.PostHeldComment(ctx, "open", 2, "spam", "", nil, "banned word"); err != nil {
+		t.Fatal("Failed to post comment:", err)
+	}
+	if err := pdb.ReportComment(ctx, 2, 3, "rude"); err != nil {
+		t.Fatal("Failed to report comment:", err)
+	}
+
+	steps := []struct {
+		action   string
+		ids      []int64
+		prefixes []string
+		err      error
+	}{
+		{data.ActionHide, []int64{1, 2}, nil, nil},
+		{data.ActionUnhide, []int64{2}, []string{"op"}, nil},
+		{data.ActionApprove, []int64{2, 4}, nil, nil},
+		{data.ActionDelete, []int64{3}, nil, nil},
+		{data.ActionHide, []int64{1, 100}, nil, data.ErrNoComment},
+		{data.ActionHide, []int64{4}, []string{"closed"}, data.ErrNotPermitted},
+		{data.ActionHide, []int64{4}, []string{}, data.ErrNotPermitted},
+		{"ban", []int64{1}, nil, data.ErrUnknownAction},
+	}
+	for _, step := range steps {
+		if err := pdb.ModerateComments(ctx, 3, step.prefixes, step.action, step.ids, "testing"); !errors.Is(err, step.err) {
+			t.Fatalf("%s %v: wanted error %v got %v", step.action, step.ids, step.err, err)
+		}
+	}
+
+	comments, err := pdb.GetModerationComments(ctx, data.ModerationFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]struct{ hidden, deleted, held bool }{
+		1: {true, false, false},
+		2: {false, false, false},
+		3: {false, true, false},
+		4: {false, false, false},
+	}
+	for _, c := range comments {
+		e := expected[c.Id]
+		if c.Hidden != e.hidden || c.Deleted != e.deleted || c.Held != e.held || c.Reports != 0 {
+			t.Errorf("Unexpected state for comment %d: %+v", c.Id, c)
+		}
+	}
+
+	actions, err := pdb.GetModerationActions(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the failed hide of comments 1 and 100 is rolled back
+	if len(actions) != 6 {
+		t.Fatalf("Expected 6 actions in the audit trail, got %d", len(actions))
+	}
+	if a := actions[0]; a.Action != data.ActionDelete || a.CommentId != 3 || a.Moderator.Id != 3 || a.Reason != "testing" {
+		t.Errorf("Unexpected latest action: %+v", a)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	pdb := openPage(fmt.Sprintf("file:%s/roles.db", t.TempDir()))
+	ctx := context.Background()
+
+	granted, err := pdb.GrantAdmins(ctx, map[string][]string{"GitHub": {"A@Z.com"}, "google": {"nobody@y.org"}})
+	if err != nil {
+		t.Fatal("Failed to grant admins:", err)
+	} else if granted != 1 {
+		t.Errorf("Expected 1 admin granted, got %d", granted)
+	}
+
+	steps := []struct {
+		userId int64
+		role   data.Role
+		pages  []string
+		err    error
+	}{
+		{2, data.RoleModerator, []string{"blog/", "docs/", "blog/"}, nil},
+		{3, data.RoleBanned, []string{"blog/"}, nil},
+		{3, "owner", nil, data.ErrUnknownRole},
+		{100, data.RoleAdmin, nil, data.ErrNoUser},
+	}
+	for _, step := range steps {
+		if err := pdb.SetRole(ctx, step.userId, step.role, step.pages); !errors.Is(err, step.err) {
+			t.Fatalf("SetRole(%d, %s): wanted error %v got %v", step.userId, step.role, step.err, err)
+		}
+	}
+
+	expected := []data.Permissions{
+		{Role: data.RoleAdmin},
+		{Role: data.RoleModerator, Pages: []string{"blog/", "docs/"}},
+		{Role: data.RoleBanned},
+	}
+	for i, e := range expected {
+		perms, err := pdb.GetPermissions(ctx, int64(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perms.Role != e.Role || fmt.Sprint(perms.Pages) != fmt.Sprint(e.Pages) {
+			t.Errorf("Unexpected permissions for user %d: wanted %+v got %+v", i+1, e, perms)
+		}
+	}
+	if _, err := pdb.GetPermissions(ctx, 100); !errors.Is(err, data.ErrNoUser) {
+		t.Errorf("Unexpected error for missing user: %v", err)
+	}
+
+	assignments, err := pdb.GetRoleAssignments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assignments) != 3 || assignments[0].User.Email != "a@z.com" || len(assignments[1].Pages) != 2 || assignments[2].Pages != nil {
+		t.Errorf("Unexpected role assignments: %+v", assignments)
+	}
+}
+
+func TestPermissionsCanModerate(t *testing.T) {
+	moderator := data.Permissions{Role: data.RoleModerator, Pages: []string{"blog/", "docs/intro"}}
+	cases := []struct {
+		perms   data.Permissions
+		pageUrl string
+		allowed bool
+	}{
+		{data.Permissions{Role: data.RoleAdmin}, "anything", true},
+		{moderator, "blog/post", true},
+		{moderator, "docs/intro/setup", true},
+		{moderator, "docs/other", false},
+		{moderator, "about", false},
+		{data.Permissions{Role: data.RoleModerator}, "blog/post", false},
+		{data.Permissions{Role: data.RoleUser, Pages: []string{"blog/"}}, "blog/post", false},
+		{data.Permissions{Role: data.RoleBanned}, "blog/post", false},
+	}
+
+	for _, tc := range cases {
+		if allowed := tc.perms.CanModerate(tc.pageUrl); allowed != tc.allowed {
+			t.Errorf("%s %v CanModerate(%q): wanted %t got %t", tc.perms.Role, tc.perms.Pages, tc.pageUrl, tc.allowed, allowed)
+		}
+	}
+}
+
+func TestOpenClosePage(t *testing.T) {
+	pdb := openPage(fmt.Sprintf("file:%s/pages.db", t.TempDir()))
+	ctx := context.WithValue(context.Background(), "now", time.Now().Unix())
+
+	if err := pdb.OpenPage(ctx, "closed", time.Unix(MaxInt64, 0)); err != nil {
+		t.Fatal("Failed to open page:", err)
+	}
+	if err := pdb.OpenPage(ctx, "blog/new", time.Unix(MaxInt64, 0)); err != nil {
+		t.Fatal("Failed to create page:", err)
+	}
+	if err := pdb.ClosePage(ctx, "open"); err != nil {
+		t.Fatal("Failed to close page:", err)
+	}
+	if err := pdb.ClosePage(ctx, "missing"); !errors.Is(err, data.ErrNoPage) {
+		t.Errorf("Unexpected error closing missing page: %v", err)
+	}
+
+	pages, err := pdb.GetPages(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := make(map[string]bool)
+	for _, page := range pages {
+		open[page.Url] = page.Open
+	}
+	if len(open) != 3 || !open["closed"] || !open["blog/new"] || open["open"] {
+		t.Errorf("Unexpected pages: %+v", pages)
+	}
+
+	pages, err = pdb.GetPages(ctx, []string{"blog/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].Url != "blog/new" {
+		t.Errorf("Unexpected pages under blog/: %+v", pages)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	pdb := openPage(fmt.Sprintf("file:%s/softdelete.db", t.TempDir()))
+	ctx := context.WithValue(context.Background(), "now", MaxInt64)
+
+	for _, content := range []string{"pie", "cobbler", "crumble"} {
+		if _, err := pdb.PostComment(ctx, "open", 1, content, "<p>"+content+"</p>", nil); err != nil {
+			t.Fatal("Failed to post comment:", err)
+		}
+	}
+	if err := pdb.ModerateComments(ctx, 2, nil, data.ActionDelete, []int64{1, 2}, "off topic"); err != nil {
+		t.Fatal("Failed to delete comments:", err)
+	}
+	if err := pdb.ModerateComments(ctx, 2, nil, data.ActionHide, []int64{3}, "spoilers"); err != nil {
+		t.Fatal("Failed to hide comment:", err)
+	}
+
+	page, err := pdb.GetPageComments(ctx, "open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range page.Comments {
+		if c.Deleted && (c.Content != "" || c.Rendered != "" || c.Reason != "off topic") {
+			t.Errorf("Expected deleted comment without content, got %+v", c)
+		} else if c.Hidden && (c.Rendered != "<p>crumble</p>" || c.Reason != "spoilers") {
+			t.Errorf("Expected hidden comment with its reason, got %+v", c)
+		}
+	}
+
+	purged, err := pdb.PurgeDeletedComments(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	} else if purged != 0 {
+		t.Errorf("Expected recently deleted comments to be kept, %d were purged", purged)
+	}
+
+	if err := pdb.ModerateComments(ctx, 2, nil, data.ActionUndelete, []int64{1}, ""); err != nil {
+		t.Fatal("Failed to undelete comment:", err)
+	}
+	comment, err := pdb.GetCommentById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment.Deleted || comment.Rendered != "<p>pie</p>" || comment.Reason != "" {
+		t.Errorf("Expected undeleted comment to be restored, got %+v", comment)
+	}
+
+	purged, err = pdb.PurgeDeletedComments(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	} else if purged != 1 {
+		t.Errorf("Expected 1 comment to be purged, got %d", purged)
+	}
+	err = pdb.ModerateComments(ctx, 2, nil, data.ActionUndelete, []int64{2}, "")
+	if !errors.Is(err, data.ErrCommentPurged) {
+		t.Errorf("Expected purged comment to stay deleted, got %v", err)
+	}
+
+	comments, err := pdb.GetModerationComments(ctx, data.ModerationFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range comments {
+		if c.Id == 2 && (!c.Purged || c.Content != "") {
+			t.Errorf("Expected purged comment without content, got %+v", c)
+		} else if c.Id != 2 && c.Purged {
+			t.Errorf("Unexpected purged comment %+v", c)
+		}
+	}
+}
+
+func TestEditComment(t *testing.T) {
+	pdb := openPage(fmt.Sprintf("file:%s/edit.db", t.TempDir()))
+	ctx := context.WithValue(context.Background(), "now", MaxInt64)
+
+	if _, err := pdb.PostComment(ctx, "open", 1, "pie", "<p>pie</p>", nil); err != nil {
+		t.Fatal("Failed to post comment:", err)
+	}
+	for _, content := range []string{"apple pie", "apple pie", "peach pie"} {
+		if err := pdb.EditComment(ctx, 1, content, "<p>"+content+"</p>", ""); err != nil {
+			t.Fatal("Failed to edit comment:", err)
+		}
+	}
+	if err := pdb.EditComment(ctx, 100, "pie", "", ""); !errors.Is(err, data.ErrNoComment) {
+		t.Errorf("Expected missing comment error, got %v", err)
+	}
+
+	comment, err := pdb.GetCommentById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment.Rendered != "<p>peach pie</p>" || comment.Edited == nil || comment.Edited.Before(comment.Posted) {
+		t.Errorf("Expected edited comment, got %+v", comment)
+	}
+
+	history, err := pdb.GetCommentHistory(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, revision := range history.Revisions {
+		contents = append(contents, revision.Content)
+	}
+	// editing without changes does not add a revision
+	if expected := []string{"pie", "apple pie", "peach pie"}; fmt.Sprint(contents) != fmt.Sprint(expected) || history.PageUrl != "open" {
+		t.Errorf("Unexpected history: wanted %q got %q on %s", expected, contents, history.PageUrl)
+	}
+	if history.Revisions[0].Rendered != "<p>pie</p>" {
+		t.Errorf("Expected revisions to keep their html, got %q", history.Revisions[0].Rendered)
+	}
+	if _, err := pdb.GetCommentHistory(ctx, 100); !errors.Is(err, data.ErrNoComment) {
+		t.Errorf("Expected missing comment error, got %v", err)
+	}
+
+	if err := pdb.DeleteComment(ctx, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := pdb.EditComment(ctx, 1, "cake", "", ""); !errors.Is(err, data.ErrNoComment) {
+		t.Errorf("Expected deleted comment to not be editable, got %v", err)
+	}
+	if _, err := pdb.PurgeDeletedComments(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	history, err = pdb.GetCommentHistory(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(history.Revisions) != 1 || history.Revisions[0].Content != "" {
+		t.Errorf("Expected purging to remove revisions, got %+v", history.Revisions)
+	}
+}
+
+func TestEditHeldComment(t *testing.T) {
+	pdb := openPage(fmt.Sprintf("file:%s/edit_held.db", t.TempDir()))
+	ctx := context.WithValue(context.Background(), "now", MaxInt64)
+
+	if _, err := pdb.PostComment(ctx, "open", 1, "pie", "<p>pie</p>", nil); err != nil {
+		t.Fatal("Failed to post comment:", err)
+	}
+	if err := pdb.EditComment(ctx, 1, "spam", "<p>spam</p>", "banned word"); err != nil {
+		t.Fatal("Failed to edit comment:", err)
+	}
+
+	comment, err := pdb.GetCommentById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !comment.Held || comment.Content != "" || comment.Edited == nil {
+		t.Errorf("Expected edited comment to be held without content, got %+v", comment)
+	}
+
+	var queued int
+	if err := pdb.Db.QueryRow("SELECT COUNT(*) FROM ModerationQueue WHERE commentId = 1").Scan(&queued); err != nil || queued != 1 {
+		t.Errorf("Expected edited comment to be queued, got %d (%v)", queued, err)
 	}
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -14,14 +16,24 @@ type SortPaginate struct {
 	Offset     int
 }
 
-// Parse a comment from a sql row
-func parseComment(ctx context.Context, row *sql.Rows, stmt *sql.Stmt, unixTime int64) (*Comment, error) {
-	comment := new(Comment)
+// The reason a moderator gave if their latest action on a comment hid or deleted it
+const moderationReason = `COALESCE((
+        SELECT CASE WHEN action IN ('hide', 'delete') THEN reason ELSE '' END
+        FROM ModerationActions WHERE commentId = Comments.id
+        ORDER BY ModerationActions.id DESC LIMIT 1), '')`
+
+// Scan the columns every comment query selects first, followed by any extra columns
+//
+// The content of held and deleted comments is kept, see scanPublicComment.
+func scanComment(scan func(...any) error, unixTime int64, comment *Comment, extra ...any) error {
 	var hiddenTime sql.NullInt64
 	var deletedTime sql.NullInt64
+	var editedTime sql.NullInt64
 	var postedTime int64
-	if err := row.Scan(&comment.Id, &hiddenTime, &deletedTime, &postedTime, &comment.Content); err != nil {
-		return nil, err
+	dest := append([]any{&comment.Id, &hiddenTime, &deletedTime, &postedTime, &comment.Content, &comment.Rendered,
+		&comment.Depth, &comment.Held, &comment.Reason, &editedTime, &comment.DeletedBy}, extra...)
+	if err := scan(dest...); err != nil {
+		return err
 	}
 
 	if hiddenTime.Valid {
@@ -32,6 +44,39 @@ func parseComment(ctx context.Context, row *sql.Rows, stmt *sql.Stmt, unixTime i
 		comment.Deleted = deletedTime.Int64 < unixTime
 	}
 	comment.Posted = time.Unix(postedTime, 0)
+	if editedTime.Valid {
+		edited := time.Unix(editedTime.Int64, 0)
+		comment.Edited = &edited
+	}
+	if comment.DeletedBy == DeletedByAuthor {
+		// an earlier moderator's reason no longer applies
+		comment.Reason = ""
+	}
+
+	return nil
+}
+
+// Scan a comment for readers, without the content of held or deleted comments
+//
+// Content is the text as written, so it is only kept for comments never rendered through the filters.
+func scanPublicComment(scan func(...any) error, unixTime int64, comment *Comment) error {
+	if err := scanComment(scan, unixTime, comment); err != nil {
+		return err
+	}
+	if comment.Held || comment.Deleted {
+		comment.Content, comment.Rendered = "", ""
+	} else if comment.Rendered != "" {
+		comment.Content = ""
+	}
+	return nil
+}
+
+// Parse a comment from a sql row
+func parseComment(ctx context.Context, row *sql.Rows, stmt *sql.Stmt, unixTime int64) (*Comment, error) {
+	comment := new(Comment)
+	if err := scanPublicComment(row.Scan, unixTime, comment); err != nil {
+		return nil, err
+	}
 
 	result, err := stmt.QueryContext(ctx, comment.Id)
 	if err == sql.ErrNoRows {
@@ -39,6 +84,7 @@ func parseComment(ctx context.Context, row *sql.Rows, stmt *sql.Stmt, unixTime i
 	} else if err != nil {
 		return comment, err
 	}
+	defer result.Close()
 
 	var replyId int
 	for result.Next() {
@@ -82,10 +128,13 @@ func (p PennyDB) GetPageCommentsById(ctx context.Context, pageId int) (*Page, er
 	}
 
 	query := `
-    SELECT id, hiddenTime, deletedTime, postedTime, content
+    SELECT Comments.id, hiddenTime, deletedTime, postedTime, content, COALESCE(html, ''), COALESCE(depth, 0),
+        ModerationQueue.commentId IS NOT NULL, ` + moderationReason + `, editedTime, COALESCE(deletedBy, '')
     FROM Comments
+    LEFT JOIN Replies ON Comments.id = Replies.childId
+    LEFT JOIN ModerationQueue ON Comments.id = ModerationQueue.commentId
     WHERE pageId = ?
-    ORDER BY postedTime`
+    ORDER BY postedTime, Comments.id`
 
 	result, err := p.Db.QueryContext(ctx, query, pageId)
 	if err != nil {
@@ -125,11 +174,14 @@ func (p PennyDB) GetPageComments(ctx context.Context, pageUrl string) (*Page, er
 	}
 
 	result, err := p.Db.QueryContext(ctx, `
-    SELECT Comments.id, hiddenTime, deletedTime, postedTime, content
+    SELECT Comments.id, hiddenTime, deletedTime, postedTime, content, COALESCE(html, ''), COALESCE(depth, 0),
+        ModerationQueue.commentId IS NOT NULL, `+moderationReason+`, editedTime, COALESCE(deletedBy, '')
     FROM Comments
     JOIN Pages ON Comments.pageId = Pages.id
+    LEFT JOIN Replies ON Comments.id = Replies.childId
+    LEFT JOIN ModerationQueue ON Comments.id = ModerationQueue.commentId
     WHERE url = ?
-    ORDER BY postedTime`, pageUrl)
+    ORDER BY postedTime, Comments.id`, pageUrl)
 	if err != nil {
 		return nil, err
 	}
@@ -175,37 +227,29 @@ func (p PennyDB) GetCommentById(ctx context.Context, commentId int) (Comment, er
 	}
 
 	row := p.Db.QueryRowContext(ctx, `
-    SELECT id, hiddenTime, deletedTime, postedTime, content
+    SELECT Comments.id, hiddenTime, deletedTime, postedTime, content, COALESCE(html, ''), COALESCE(depth, 0),
+        ModerationQueue.commentId IS NOT NULL, `+moderationReason+`, editedTime, COALESCE(deletedBy, '')
     FROM Comments
-    WHERE id = ?`, commentId)
+    LEFT JOIN Replies ON Comments.id = Replies.childId
+    LEFT JOIN ModerationQueue ON Comments.id = ModerationQueue.commentId
+    WHERE Comments.id = ?`, commentId)
 
 	comment := Comment{}
-	var hiddenTime sql.NullInt64
-	var deletedTime sql.NullInt64
-	var postedTime int64
-	if err := row.Scan(&comment.Id, &hiddenTime, &deletedTime, &postedTime, &comment.Content); err != nil {
+	if err := scanPublicComment(row.Scan, now, &comment); err != nil {
 		return Comment{}, err
 	}
 
-	if hiddenTime.Valid {
-		comment.Hidden = hiddenTime.Int64 < now
-	}
-
-	if deletedTime.Valid {
-		comment.Deleted = deletedTime.Int64 < now
-	}
-	comment.Posted = time.Unix(postedTime, 0)
-
 	result, err := p.Db.QueryContext(ctx, `
     SELECT childId
     FROM Replies
     WHERE parentId = ?
-    ORDER BY childId`)
+    ORDER BY childId`, commentId)
 	if err == sql.ErrNoRows {
 		return comment, nil
 	} else if err != nil {
 		return Comment{}, err
 	}
+	defer result.Close()
 
 	var replyId int
 	for result.Next() {
@@ -247,3 +291,318 @@ func (p PennyDB) GetPagesInfo(ctx context.Context) ([]PageInfo, error) {
 
 	return pageInfos, nil
 }
+
+// Get an unexpired session and its user
+//
+// A token replaced by rotation still finds its session until its grace period ends.
+func (p PennyDB) GetSession(ctx context.Context, tokenHash string) (Session, error) {
+	now, ok := ctx.Value("now").(int64)
+	if !ok {
+		return Session{}, errors.New("Missing `now` in context")
+	}
+
+	session := Session{}
+	var created, rotated, expires int64
+	var email, name sql.NullString
+	err := p.Db.QueryRowContext(ctx, `
+    SELECT Sessions.id, createdTime, rotatedTime, expiresTime, Users.id, email, provider, name, role
+    FROM Sessions
+    JOIN Users ON Sessions.userId = Users.id
+    WHERE (tokenHash = ? OR (previousTokenHash = ? AND previousExpiresTime > ?)) AND expiresTime > ?`,
+		tokenHash, tokenHash, now, now).Scan(
+		&session.Id, &created, &rotated, &expires,
+		&session.User.Id, &email, &session.User.Provider, &name, &session.User.Role)
+	if err == sql.ErrNoRows {
+		return Session{}, ErrNoSession
+	} else if err != nil {
+		return Session{}, err
+	}
+
+	session.User.Email = email.String
+	session.User.Name = name.String
+	session.Created = time.Unix(created, 0)
+	session.Rotated = time.Unix(rotated, 0)
+	session.Expires = time.Unix(expires, 0)
+
+	return session, nil
+}
+
+// Get the id of the user who posted a comment
+func (p PennyDB) GetCommentAuthor(ctx context.Context, commentId int64) (int64, error) {
+	var userId int64
+	err := p.Db.QueryRowContext(ctx, "SELECT userId FROM Comments WHERE id = ?", commentId).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return -1, ErrNoComment
+	}
+
+	return userId, err
+}
+
+// Get comments from every page for moderators, newest first
+//
+// Unlike other queries the content of held and deleted comments is included until it is purged.
+func (p PennyDB) GetModerationComments(ctx context.Context, filter ModerationFilter) ([]ModerationComment, error) {
+	now, ok := ctx.Value("now").(int64)
+	if !ok {
+		return nil, errors.New("Missing `now` in context")
+	}
+
+	query := `
+    SELECT Comments.id, hiddenTime, deletedTime, postedTime, content, COALESCE(html, ''), COALESCE(depth, 0),
+        ModerationQueue.commentId IS NOT NULL, ` + moderationReason + `, editedTime, COALESCE(deletedBy, ''),
+        url, Users.id, COALESCE(email, ''), provider, COALESCE(name, ''),
+        COALESCE(ModerationQueue.reason, ''), COUNT(Reports.id), purgedTime IS NOT NULL
+    FROM Comments
+    JOIN Pages ON Comments.pageId = Pages.id
+    JOIN Users ON Comments.userId = Users.id
+    LEFT JOIN Replies ON Comments.id = Replies.childId
+    LEFT JOIN ModerationQueue ON Comments.id = ModerationQueue.commentId
+    LEFT JOIN Reports ON Comments.id = Reports.commentId
+    WHERE 1 = 1`
+	var args []any
+	switch filter.Queue {
+	case "":
+	case "held":
+		query += " AND ModerationQueue.commentId IS NOT NULL"
+	case "reported":
+		query += " AND EXISTS (SELECT 1 FROM Reports WHERE Reports.commentId = Comments.id)"
+	default:
+		return nil, fmt.Errorf("Unknown moderation queue %q", filter.Queue)
+	}
+	if filter.PageUrl != "" {
+		query += " AND url = ?"
+		args = append(args, filter.PageUrl)
+	}
+	if filter.PagePrefixes != nil {
+		clause, prefixArgs := prefixClause("url", filter.PagePrefixes)
+		query += " AND " + clause
+		args = append(args, prefixArgs...)
+	}
+	if filter.UserId != 0 {
+		query += " AND Users.id = ?"
+		args = append(args, filter.UserId)
+	}
+	if !filter.Since.IsZero() {
+		query += " AND postedTime >= ?"
+		args = append(args, filter.Since.UTC().Unix())
+	}
+	if !filter.Until.IsZero() {
+		query += " AND postedTime < ?"
+		args = append(args, filter.Until.UTC().Unix())
+	}
+	query += " GROUP BY Comments.id ORDER BY postedTime DESC, Comments.id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := p.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []ModerationComment
+	for rows.Next() {
+		var c ModerationComment
+		err := scanComment(rows.Scan, now, &c.Comment,
+			&c.PageUrl, &c.Author.Id, &c.Author.Email, &c.Author.Provider, &c.Author.Name,
+			&c.HeldReason, &c.Reports, &c.Purged)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+
+	return comments, rows.Err()
+}
+
+// Get the most recent moderation actions, newest first
+func (p PennyDB) GetModerationActions(ctx context.Context, limit int) ([]ModerationAction, error) {
+	rows, err := p.Db.QueryContext(ctx, `
+    SELECT ModerationActions.id, commentId, Users.id, COALESCE(email, ''), provider, COALESCE(name, ''),
+        action, reason, actionTime
+    FROM ModerationActions
+    JOIN Users ON ModerationActions.userId = Users.id
+    ORDER BY actionTime DESC, ModerationActions.id DESC
+    LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []ModerationAction
+	for rows.Next() {
+		var a ModerationAction
+		var actionTime int64
+		err := rows.Scan(&a.Id, &a.CommentId, &a.Moderator.Id, &a.Moderator.Email, &a.Moderator.Provider, &a.Moderator.Name,
+			&a.Action, &a.Reason, &actionTime)
+		if err != nil {
+			return nil, err
+		}
+		a.Time = time.Unix(actionTime, 0)
+		actions = append(actions, a)
+	}
+
+	return actions, rows.Err()
+}
+
+// A condition matching column values starting with any of prefixes, never true without prefixes
+func prefixClause(column string, prefixes []string) (string, []any) {
+	if len(prefixes) == 0 {
+		return "0", nil
+	}
+
+	conditions := make([]string, len(prefixes))
+	args := make([]any, 0, 2*len(prefixes))
+	for i, prefix := range prefixes {
+		conditions[i] = fmt.Sprintf("substr(%s, 1, length(?)) = ?", column)
+		args = append(args, prefix, prefix)
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// Get a user's role and the pages they may moderate
+func (p PennyDB) GetPermissions(ctx context.Context, userId int64) (Permissions, error) {
+	var perms Permissions
+	err := p.Db.QueryRowContext(ctx, "SELECT role FROM Users WHERE id = ?", userId).Scan(&perms.Role)
+	if err == sql.ErrNoRows {
+		return perms, ErrNoUser
+	} else if err != nil {
+		return perms, err
+	}
+
+	if perms.Role != RoleModerator {
+		return perms, nil
+	}
+
+	rows, err := p.Db.QueryContext(ctx, "SELECT prefix FROM ModeratorPages WHERE userId = ? ORDER BY prefix", userId)
+	if err != nil {
+		return perms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var prefix string
+		if err := rows.Scan(&prefix); err != nil {
+			return perms, err
+		}
+		perms.Pages = append(perms.Pages, prefix)
+	}
+
+	return perms, rows.Err()
+}
+
+// Get every user with a role other than RoleUser and the pages moderators may moderate
+func (p PennyDB) GetRoleAssignments(ctx context.Context) ([]RoleAssignment, error) {
+	rows, err := p.Db.QueryContext(ctx, `
+    SELECT Users.id, COALESCE(email, ''), provider, COALESCE(name, ''), role, COALESCE(prefix, '')
+    FROM Users
+    LEFT JOIN ModeratorPages ON Users.id = ModeratorPages.userId AND role = ?
+    WHERE role != ?
+    ORDER BY Users.id, prefix`, RoleModerator, RoleUser)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assignments []RoleAssignment
+	for rows.Next() {
+		var user User
+		var prefix string
+		err := rows.Scan(&user.Id, &user.Email, &user.Provider, &user.Name, &user.Role, &prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(assignments); n == 0 || assignments[n-1].User.Id != user.Id {
+			assignments = append(assignments, RoleAssignment{User: user})
+		}
+		if prefix != "" {
+			last := &assignments[len(assignments)-1]
+			last.Pages = append(last.Pages, prefix)
+		}
+	}
+
+	return assignments, rows.Err()
+}
+
+// Get pages whose urls start with any of prefixes, including pages without comments
+//
+// Every page is returned when prefixes is nil.
+func (p PennyDB) GetPages(ctx context.Context, prefixes []string) ([]PageInfo, error) {
+	now, ok := ctx.Value("now").(int64)
+	if !ok {
+		return nil, errors.New("Missing `now` in context")
+	}
+
+	query := `
+    SELECT url, commentsOpenTime, COALESCE(MAX(postedTime), 0), COUNT(Comments.id)
+    FROM Pages LEFT JOIN Comments
+    ON Pages.id = Comments.pageId`
+	var args []any
+	if prefixes != nil {
+		var clause string
+		clause, args = prefixClause("url", prefixes)
+		query += " WHERE " + clause
+	}
+	query += " GROUP BY Pages.id ORDER BY url"
+
+	rows, err := p.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pages []PageInfo
+	for rows.Next() {
+		pageInfo, err := parsePageInfo(rows, now)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, pageInfo)
+	}
+
+	return pages, rows.Err()
+}
+
+// Get every version of a comment for moderators, including the content of deleted comments until it is purged
+func (p PennyDB) GetCommentHistory(ctx context.Context, commentId int64) (CommentHistory, error) {
+	history := CommentHistory{CommentId: commentId}
+	var current Revision
+	var writtenTime int64
+	err := p.Db.QueryRowContext(ctx, `
+    SELECT url, content, COALESCE(html, ''), COALESCE(editedTime, postedTime)
+    FROM Comments JOIN Pages ON Comments.pageId = Pages.id
+    WHERE Comments.id = ?`, commentId).Scan(&history.PageUrl, &current.Content, &current.Rendered, &writtenTime)
+	if err == sql.ErrNoRows {
+		return history, ErrNoComment
+	} else if err != nil {
+		return history, err
+	}
+	current.Written = time.Unix(writtenTime, 0)
+
+	rows, err := p.Db.QueryContext(ctx, `
+    SELECT content, COALESCE(html, ''), writtenTime FROM CommentRevisions
+    WHERE commentId = ?
+    ORDER BY writtenTime, id`, commentId)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var revision Revision
+		if err := rows.Scan(&revision.Content, &revision.Rendered, &writtenTime); err != nil {
+			return history, err
+		}
+		revision.Written = time.Unix(writtenTime, 0)
+		history.Revisions = append(history.Revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return history, err
+	}
+
+	history.Revisions = append(history.Revisions, current)
+	return history, nil
+}
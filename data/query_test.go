@@ -3,7 +3,10 @@ package data_test
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/jpappel/penny/data"
 )
@@ -150,3 +153,78 @@ func TestGetPageComments(t *testing.T) {
 func TestGetPageInfos(t *testing.T) {
     // TODO: implement test
 }
+
+func TestGetModerationComments(t *testing.T) {
+	pdb := openPage(fmt.Sprintf("file:%s/moderation.db", t.TempDir()))
+	ctx := context.WithValue(context.Background(), "now", MaxInt64)
+
+	if _, err := pdb.Db.Exec("INSERT INTO Pages(url, commentsOpenTime) VALUES (?,?)", "other", MaxInt64); err != nil {
+		t.Fatal(err)
+	}
+	posts := []struct {
+		page   string
+		userId int64
+		held   string
+	}{
+		{"open", 1, ""}, {"open", 2, "spam"}, {"other", 1, ""}, {"other", 3, ""},
+	}
+	for _, post := range posts {
+		if _, err := pdb.PostHeldComment(ctx, post.page, post.userId, "pie", "", nil, post.held); err != nil {
+			t.Fatal("Failed to post comment:", err)
+		}
+	}
+	for _, userId := range []int64{1, 2} {
+		if err := pdb.ReportComment(ctx, 4, userId, "rude"); err != nil {
+			t.Fatal("Failed to report comment:", err)
+		}
+	}
+	if _, err := pdb.Db.Exec("UPDATE Comments SET postedTime = id * 100"); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		filter   data.ModerationFilter
+		expected []int
+	}{
+		{"All", data.ModerationFilter{}, []int{4, 3, 2, 1}},
+		{"Limit", data.ModerationFilter{Limit: 2}, []int{4, 3}},
+		{"Held", data.ModerationFilter{Queue: "held"}, []int{2}},
+		{"Reported", data.ModerationFilter{Queue: "reported"}, []int{4}},
+		{"Page", data.ModerationFilter{PageUrl: "other"}, []int{4, 3}},
+		{"Page Prefixes", data.ModerationFilter{PagePrefixes: []string{"oth", "missing"}}, []int{4, 3}},
+		{"No Page Prefixes", data.ModerationFilter{PagePrefixes: []string{}}, nil},
+		{"User", data.ModerationFilter{UserId: 1}, []int{3, 1}},
+		{"Dates", data.ModerationFilter{Since: time.Unix(200, 0), Until: time.Unix(400, 0)}, []int{3, 2}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			comments, err := pdb.GetModerationComments(ctx, tc.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, c := range comments {
+				ids = append(ids, c.Id)
+			}
+			if !slices.Equal(ids, tc.expected) {
+				t.Errorf("Unexpected comments: wanted %v got %v", tc.expected, ids)
+			}
+		})
+	}
+
+	comments, err := pdb.GetModerationComments(ctx, data.ModerationFilter{Queue: "held"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := comments[0]; c.Content != "pie" || c.HeldReason != "spam" || c.Author.Email != "b@y.org" || c.PageUrl != "open" {
+		t.Errorf("Expected held comment with its content and author, got %+v", c)
+	}
+	comments, err = pdb.GetModerationComments(ctx, data.ModerationFilter{Queue: "reported"})
+	if err != nil {
+		t.Fatal(err)
+	} else if comments[0].Reports != 2 {
+		t.Errorf("Expected 2 reports, got %d", comments[0].Reports)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 )
@@ -14,36 +15,180 @@ type PennyDB struct {
 	Db *sql.DB // public for testing purposes
 }
 
+type User struct {
+	Id       int64  `json:"id"`
+	Email    string `json:"email"`
+	Provider string `json:"provider"`
+	Name     string `json:"name"`
+	Role     Role   `json:"role"`
+}
+
+// What a user is allowed to do
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+	// cannot post, edit or report comments
+	RoleBanned Role = "banned"
+)
+
+var Roles = []Role{RoleUser, RoleModerator, RoleAdmin, RoleBanned}
+
+// A user's role and the pages they may moderate
+type Permissions struct {
+	Role Role
+	// url prefixes of the pages a moderator may moderate
+	Pages []string
+}
+
+// Report if the permissions allow moderating a page
+func (p Permissions) CanModerate(pageUrl string) bool {
+	switch p.Role {
+	case RoleAdmin:
+		return true
+	case RoleModerator:
+		return hasPrefix(pageUrl, p.Pages)
+	default:
+		return false
+	}
+}
+
+func hasPrefix(s string, prefixes []string) bool {
+	return slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(s, prefix) })
+}
+
+// Url prefixes moderation is limited to, nil when every page may be moderated
+func (p Permissions) PagePrefixes() []string {
+	if p.Role == RoleAdmin {
+		return nil
+	}
+	return p.Pages
+}
+
+// A user with a role and the pages they may moderate
+type RoleAssignment struct {
+	User  User     `json:"user"`
+	Pages []string `json:"pages"`
+}
+
+type Session struct {
+	Id      int64
+	User    User
+	Created time.Time
+	Rotated time.Time
+	Expires time.Time
+}
+
 type Comment struct {
-	Id      int
-	Content string
-	Hidden  bool
-	Deleted bool
-	Posted  time.Time
-	Replies []int
+	Id int `json:"id"`
+	// Text as written before filtering, readers only get it for comments without html
+	Content string    `json:"content,omitempty"`
+	Hidden  bool      `json:"hidden"`
+	Deleted bool      `json:"deleted"`
+	Posted  time.Time `json:"posted"`
+	Replies []int     `json:"replies"`
+	Depth   int       `json:"depth"`
+	// Content filtered and rendered as html, empty for deleted comments
+	Rendered string `json:"html,omitempty"`
+	// Waiting for a moderator, content is empty until it is approved
+	Held bool `json:"held"`
+	// Why a moderator hid or deleted the comment
+	Reason string `json:"reason,omitempty"`
+	// When the author last edited the comment, nil if it was never edited
+	Edited *time.Time `json:"edited,omitempty"`
+	// DeletedByAuthor or DeletedByModerator for deleted comments
+	DeletedBy string `json:"deletedBy,omitempty"`
+}
+
+// Who deleted a comment
+const (
+	DeletedByAuthor    = "author"
+	DeletedByModerator = "moderator"
+)
+
+// A version of a comment's content
+type Revision struct {
+	Content  string    `json:"content"`
+	Rendered string    `json:"html,omitempty"`
+	Written  time.Time `json:"written"`
+}
+
+// Every version of a comment, oldest first and ending with its current content
+type CommentHistory struct {
+	CommentId int64      `json:"commentId"`
+	PageUrl   string     `json:"pageUrl"`
+	Revisions []Revision `json:"revisions"`
 }
 
 type PageInfo struct {
-	Url         string
-	UpdateTime  time.Time // TODO: change update time to time of last comment post
-	Open        bool
-	NumComments int
+	Url         string    `json:"url"`
+	UpdateTime  time.Time `json:"updateTime"` // TODO: change update time to time of last comment post
+	Open        bool      `json:"open"`
+	NumComments int       `json:"numComments"`
 }
 
 // TODO: put pages into a pool
 type Page struct {
 	PageInfo
-	Comments []Comment
+	Comments []Comment `json:"comments"`
 }
 
+// A comment across any page with the details moderators need
+type ModerationComment struct {
+	Comment
+	PageUrl string `json:"pageUrl"`
+	Author  User   `json:"author"`
+	// why the filters held the comment, empty if it is not held
+	HeldReason string `json:"heldReason,omitempty"`
+	Reports    int    `json:"reports"`
+	// the content of a deleted comment was removed and it cannot be undeleted
+	Purged bool `json:"purged"`
+}
+
+// Which comments to list for moderators, zero values match every comment
+type ModerationFilter struct {
+	// "held" or "reported" to list only those comments
+	Queue   string
+	PageUrl string
+	// url prefixes comments must be on, nil for every page
+	PagePrefixes []string
+	UserId       int64
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+}
+
+// A change made by a moderator
+type ModerationAction struct {
+	Id        int64     `json:"id"`
+	CommentId int64     `json:"commentId"`
+	Moderator User      `json:"moderator"`
+	Action    string    `json:"action"`
+	Reason    string    `json:"reason,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+const (
+	ActionHide     = "hide"
+	ActionUnhide   = "unhide"
+	ActionDelete   = "delete"
+	ActionUndelete = "undelete"
+	ActionApprove  = "approve"
+)
+
+// Actions moderators can take on comments
+var ModerationActions = []string{ActionHide, ActionUnhide, ActionDelete, ActionUndelete, ActionApprove}
+
 func (c Comment) String() string {
-	formatStr := "Comment %d: hidden[%t] deleted[%t]\nPosted (UTC) %s\n%d Children\n---\n%s"
+	formatStr := "Comment %d: hidden[%t] deleted[%t] depth[%d]\nPosted (UTC) %s\n%d Children\n---\n%s"
 	return fmt.Sprintf(formatStr,
-		c.Id, c.Hidden, c.Deleted, c.Posted.String(), len(c.Replies), c.Content)
+		c.Id, c.Hidden, c.Deleted, c.Depth, c.Posted.String(), len(c.Replies), c.Content)
 }
 
 func (c Comment) Hash() string {
-	str := fmt.Sprint(c.Id, c.Content, c.Hidden, c.Deleted, c.Posted.UTC().Unix(), len(c.Replies), c.Replies)
+	str := fmt.Sprint(c.Id, c.Content, c.Hidden, c.Deleted, c.Posted.UTC().Unix(), len(c.Replies), c.Replies, c.Depth)
 	hash := sha256.Sum256([]byte(str))
 	return hex.EncodeToString(hash[:])
 }
@@ -61,3 +206,13 @@ func (p Page) String() string {
 }
 
 var ErrNoPage error = errors.New("No matching page")
+var ErrNoUser error = errors.New("No matching user")
+var ErrPageClosed error = errors.New("Comments on page are closed")
+var ErrNoParent error = errors.New("No matching parent comment on page")
+var ErrNoSession error = errors.New("No matching session")
+var ErrNoComment error = errors.New("No matching comment")
+var ErrUnknownAction error = errors.New("Unknown moderation action")
+var ErrNotPermitted error = errors.New("Not permitted to moderate this page")
+var ErrCommentPurged error = errors.New("Deleted comment was purged")
+var ErrNotAuthor error = errors.New("Only the author can change a comment")
+var ErrUnknownRole error = errors.New("Unknown role")
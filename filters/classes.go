@@ -0,0 +1,117 @@
+package filters
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// CSS classes added to rendered markdown, empty names are left unset
+type ClassNames struct {
+	Heading    string `json:"heading"`
+	CodeBlock  string `json:"code_block"`
+	Blockquote string `json:"blockquote"`
+	Link       string `json:"link"`
+	Table      string `json:"table"`
+}
+
+var DefaultClassNames = ClassNames{
+	Heading:    "penny-heading",
+	CodeBlock:  "penny-code",
+	Blockquote: "penny-quote",
+	Link:       "penny-link",
+	Table:      "penny-table",
+}
+
+// Highest heading comments may use, so they never clash with the host page's h1 and h2
+const MinHeadingLevel = 3
+
+// Goldmark extension which tags nodes with penny classes and demotes headings
+func PennyClasses(names ClassNames) goldmark.Extender {
+	return pennyClasses{names}
+}
+
+type pennyClasses struct {
+	names ClassNames
+}
+
+func (e pennyClasses) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(e, 100),
+	))
+	// the default renderer drops attributes on code blocks
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(codeBlockRenderer{}, 100),
+	))
+}
+
+func (e pennyClasses) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		var class string
+		switch n := n.(type) {
+		case *ast.Heading:
+			n.Level = min(n.Level+MinHeadingLevel-1, 6)
+			class = e.names.Heading
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			class = e.names.CodeBlock
+		case *ast.Blockquote:
+			class = e.names.Blockquote
+		case *ast.Link, *ast.AutoLink:
+			class = e.names.Link
+		case *east.Table:
+			class = e.names.Table
+		}
+
+		if class != "" {
+			n.SetAttributeString("class", []byte(class))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// Renders code blocks with their class on the pre element
+type codeBlockRenderer struct{}
+
+func (r codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindCodeBlock, r.render)
+	reg.Register(ast.KindFencedCodeBlock, r.render)
+}
+
+func (r codeBlockRenderer) render(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		_, _ = w.WriteString("</code></pre>\n")
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString("<pre")
+	if class, ok := n.AttributeString("class"); ok {
+		_, _ = w.WriteString(` class="`)
+		_, _ = w.Write(util.EscapeHTML(class.([]byte)))
+		_ = w.WriteByte('"')
+	}
+	_, _ = w.WriteString("><code")
+	if fenced, ok := n.(*ast.FencedCodeBlock); ok {
+		if lang := fenced.Language(source); lang != nil {
+			_, _ = w.WriteString(` class="language-`)
+			_, _ = w.Write(util.EscapeHTML(lang))
+			_ = w.WriteByte('"')
+		}
+	}
+	_ = w.WriteByte('>')
+
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		html.DefaultWriter.RawWrite(w, line.Value(source))
+	}
+	return ast.WalkContinue, nil
+}
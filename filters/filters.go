@@ -1,12 +1,15 @@
 package filters
 
 import (
+	"bytes"
+	"errors"
 	"io"
-	"strings"
+	"slices"
 	"unicode"
+	"unicode/utf8"
 )
 
-var AvailableFilters map[string]Filterer
+var AvailableFilters = make(map[string]Filterer)
 
 // should be thread safe
 // should not modify input slice
@@ -14,65 +17,232 @@ type Filterer interface {
 	Filter([]byte) ([]byte, error)
 }
 
+// What a filter decided to do with a comment, later verdicts outrank earlier ones
+type Verdict int
+
+const (
+	Allow Verdict = iota
+	Rewrite
+	Hold
+	Reject
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Allow:
+		return "allow"
+	case Rewrite:
+		return "rewrite"
+	case Hold:
+		return "hold"
+	case Reject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+// A filter's verdict on a comment and the text to keep
+type Result struct {
+	Verdict Verdict
+	// the filtered text, nil when rejected
+	Text []byte
+	// why a comment was held or rejected, shown to moderators or the commenter
+	Reason string
+}
+
+// A Filterer which can also hold comments for moderation
+type Judge interface {
+	Filterer
+	Judge([]byte) Result
+}
+
+// Returned when a filter rejects a comment
+type RejectedError struct {
+	Reason string
+}
+
+func (e RejectedError) Error() string {
+	return e.Reason
+}
+
+// Run a filter, judging comments by its output if it is not a Judge
+func Apply(f Filterer, p []byte) Result {
+	if j, ok := f.(Judge); ok {
+		return j.Judge(p)
+	}
+
+	filtered, err := f.Filter(p)
+	return filterResult(p, filtered, err)
+}
+
+// The result of a filter which can only rewrite or reject
+func filterResult(p []byte, filtered []byte, err error) Result {
+	if err != nil {
+		return Result{Verdict: Reject, Reason: err.Error()}
+	} else if bytes.Equal(p, filtered) {
+		return Result{Verdict: Allow, Text: p}
+	}
+	return Result{Verdict: Rewrite, Text: filtered}
+}
+
+// Most bytes a FilterWriter holds waiting for a blank line
+const DefaultChunkSize = 1 << 16
+
+var ErrWriterClosed error = errors.New("Write to a closed FilterWriter")
+
+var paragraphBreak = []byte("\n\n")
+
+// Filters text written to it before passing it on to Writer
+//
+// Text is filtered a paragraph at a time so words split across writes are still matched,
+// but matches never span a blank line. A paragraph is held until the blank line ending it
+// is written, Flush or Close filter whatever remains.
 type FilterWriter struct {
 	Filters []Filterer
 	Writer  io.Writer
+	// most bytes held before filtering up to the last whitespace, DefaultChunkSize when 0
+	ChunkSize int
+	// the strongest verdict of the filters over everything written
+	Verdict Verdict
+	// reasons given by filters holding the text
+	Held []string
+
+	pending []byte
+	err     error
+	closed  bool
 }
 
-type WordFilter struct {
-	Words       map[string]bool
-	Replacement string
+// Create a FilterWriter passing filtered text on to w
+func NewFilterWriter(w io.Writer, filters ...Filterer) *FilterWriter {
+	return &FilterWriter{Filters: filters, Writer: w}
 }
 
-func (fw FilterWriter) Write(p []byte) (int, error) {
-	var err error
-	filtered := p
+// Buffer p, filtering and writing every paragraph it completes
+//
+// Once a filter rejects the text or Writer fails every later call returns the same error,
+// a rejection is a RejectedError.
+func (fw *FilterWriter) Write(p []byte) (int, error) {
+	if fw.err != nil {
+		return 0, fw.err
+	} else if fw.closed {
+		return 0, ErrWriterClosed
+	}
 
-	for _, filter := range fw.Filters {
-		filtered, err = filter.Filter(filtered)
-		if err != nil {
+	// only the end of the held text and p can contain a new break
+	start := max(len(fw.pending)-len(paragraphBreak)+1, 0)
+	fw.pending = append(fw.pending, p...)
+	for {
+		i := bytes.Index(fw.pending[start:], paragraphBreak)
+		if i < 0 {
+			break
+		}
+		if err := fw.filter(start + i + len(paragraphBreak)); err != nil {
+			return 0, err
+		}
+		start = 0
+	}
+
+	chunkSize := fw.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	// a rune split across writes is held until the rest of it arrives
+	for complete := completeRunes(fw.pending); complete > chunkSize; complete = completeRunes(fw.pending) {
+		if err := fw.filter(splitChunk(fw.pending[:complete], chunkSize)); err != nil {
 			return 0, err
 		}
 	}
 
-	return fw.Writer.Write(filtered)
+	return len(p), nil
 }
 
-func (f WordFilter) Filter(p []byte) ([]byte, error) {
-	result := strings.Builder{}
-	result.Grow(len(p))
-	accum := strings.Builder{}
+// Filter and write any held text
+func (fw *FilterWriter) Flush() error {
+	if fw.err != nil {
+		return fw.err
+	} else if len(fw.pending) == 0 {
+		return nil
+	}
+	return fw.filter(len(fw.pending))
+}
 
-	for _, r := range string(p) {
-		if !unicode.IsSpace(r) {
-			accum.WriteRune(r)
-			continue
-		}
+// Flush the held text, later writes fail with ErrWriterClosed
+//
+// Writer is not closed.
+func (fw *FilterWriter) Close() error {
+	if fw.closed {
+		return fw.err
+	}
+	fw.closed = true
+	return fw.Flush()
+}
 
-		if f.Words[accum.String()] {
-			result.WriteString(f.Replacement)
-		} else {
-			result.WriteString(accum.String())
+// Run the filters over the first n held bytes and write the result
+func (fw *FilterWriter) filter(n int) error {
+	filtered := fw.pending[:n]
+	for _, filter := range fw.Filters {
+		result := Apply(filter, filtered)
+		switch result.Verdict {
+		case Reject:
+			fw.Verdict = Reject
+			fw.err = RejectedError{result.Reason}
+			return fw.err
+		case Hold:
+			if !slices.Contains(fw.Held, result.Reason) {
+				fw.Held = append(fw.Held, result.Reason)
+			}
 		}
-		result.WriteRune(r)
-		accum.Reset()
+		fw.Verdict = max(fw.Verdict, result.Verdict)
+		filtered = result.Text
 	}
 
-	if accum.Len() != 0 {
-		if f.Words[accum.String()] {
-			result.WriteString(f.Replacement)
-		} else {
-			result.WriteString(accum.String())
-		}
+	if _, err := fw.Writer.Write(filtered); err != nil {
+		fw.err = err
+		return err
 	}
 
-	return []byte(result.String()), nil
+	fw.pending = append(fw.pending[:0], fw.pending[n:]...)
+	return nil
 }
 
-func init() {
-	AvailableFilters = make(map[string]Filterer)
+// Length of text without a trailing incomplete rune
+func completeRunes(text []byte) int {
+	for i := len(text) - 1; i >= 0 && i >= len(text)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(text[i]) {
+			if !utf8.FullRune(text[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(text)
+}
 
-	bannedWords := make(map[string]bool)
+// Find where to split text longer than size, after its last whitespace or else on a rune boundary
+func splitChunk(text []byte, size int) int {
+	split := 0
+	for i := 0; i < size; {
+		r, width := utf8.DecodeRune(text[i:])
+		if i+width > size {
+			break
+		}
+		i += width
+		if unicode.IsSpace(r) {
+			split = i
+		}
+	}
+	if split > 0 {
+		return split
+	}
 
-	AvailableFilters["testFilter"] = WordFilter{Words: bannedWords, Replacement: ""}
+	split = size
+	for split > 0 && !utf8.RuneStart(text[split]) {
+		split--
+	}
+	if split == 0 {
+		// size is smaller than the first rune
+		_, split = utf8.DecodeRune(text)
+	}
+	return split
 }
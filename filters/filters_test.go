@@ -1,8 +1,12 @@
 package filters_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"github.com/jpappel/penny/filters"
 	"testing"
+	"unicode/utf8"
 )
 
 type FiltererTestCase struct {
@@ -42,9 +46,234 @@ func (tc FiltererTestCase) Test(t *testing.T) {
 func TestFilterers(t *testing.T) {
 	cases := []FiltererTestCase{
 		{"Empty", filters.WordFilter{}, []byte("meep moop this is a test"), []byte("meep moop this is a test"), nil},
+		{"Replace", filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "****"}, []byte("meep moop this is a moop"), []byte("meep **** this is a ****"), nil},
+		{"Reject", filters.WordFilter{Words: map[string]bool{"moop": true}, Action: filters.RejectWords}, []byte("meep moop"), nil, filters.ErrBannedWord},
+		{"Case", filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x"}, []byte("MOOP Moop mOoP"), []byte("x x x"), nil},
+		{"Punctuation", filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x"}, []byte(`"moop," (moop)! moop...`), []byte(`"x," (x)! x...`), nil},
+		{"Inner Punctuation", filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x"}, []byte("moop-meep mo.op"), []byte("moop-meep mo.op"), nil},
+		{"Full Width", filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x"}, []byte("ｍｏｏｐ ＭＯＯＰ"), []byte("x x"), nil},
+		{"Homoglyph", filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x"}, []byte("mоор"), []byte("x"), nil},
+		{"No Substring", filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x"}, []byte("moops smoop"), []byte("moops smoop"), nil},
+		{"Substring", filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x", Substring: true}, []byte("moops, SMOOP"), []byte("x, x"), nil},
+		{"No Leetspeak", filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x"}, []byte("m00p"), []byte("m00p"), nil},
+		{"Leetspeak", filters.WordFilter{Words: map[string]bool{"moop": true, "ass": true}, Replacement: "x", Leetspeak: true}, []byte("m00p @$$ m0op."), []byte("x x x."), nil},
+		{"Preserve Length", filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "#", PreserveLength: true}, []byte("moop! ｍｏｏｐ"), []byte("####! ####"), nil},
+		{"Preserve Length Default", filters.WordFilter{Words: map[string]bool{"moop": true}, PreserveLength: true}, []byte("moop"), []byte("****"), nil},
+		{"Whitespace", filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x"}, []byte("  moop\n\tmoop "), []byte("  x\n\tx "), nil},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, tc.Test)
 	}
 }
+
+func TestNormalizeWord(t *testing.T) {
+	cases := []struct {
+		word     string
+		leet     bool
+		expected string
+	}{
+		{"Apple", false, "apple"},
+		{"ＡＰＰＬＥ", false, "apple"},
+		{"Straße", false, "strasse"},
+		{"аррlе", false, "apple"},
+		{"4ppl3", false, "4ppl3"},
+		{"4ppl3", true, "apple"},
+	}
+
+	for _, tc := range cases {
+		if word := filters.NormalizeWord(tc.word, tc.leet); word != tc.expected {
+			t.Errorf("NormalizeWord(%q, %t): wanted %q got %q", tc.word, tc.leet, tc.expected, word)
+		}
+	}
+}
+
+func TestFilterWriterVerdicts(t *testing.T) {
+	hold := filters.WordFilter{Words: map[string]bool{"spam": true}, Action: filters.HoldWords}
+	replace := filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x"}
+	reject := filters.WordFilter{Words: map[string]bool{"apple": true}, Action: filters.RejectWords}
+
+	cases := []struct {
+		name     string
+		input    string
+		verdict  filters.Verdict
+		output   string
+		held     int
+		rejected bool
+	}{
+		{"Allow", "meep", filters.Allow, "meep", 0, false},
+		{"Rewrite", "meep moop", filters.Rewrite, "meep x", 0, false},
+		{"Hold", "spam moop", filters.Hold, "spam x", 1, false},
+		{"Reject", "spam apple", filters.Reject, "", 1, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			fw := filters.NewFilterWriter(buf, hold, replace, reject)
+			_, err := fw.Write([]byte(tc.input))
+			if err == nil {
+				err = fw.Close()
+			}
+
+			var rejected filters.RejectedError
+			if errors.As(err, &rejected) != tc.rejected {
+				t.Errorf("Unexpected error: %v", err)
+			} else if tc.rejected && rejected.Reason != filters.ErrBannedWord.Error() {
+				t.Errorf("Unexpected reason: %q", rejected.Reason)
+			}
+			if fw.Verdict != tc.verdict {
+				t.Errorf("Unexpected verdict: wanted %s got %s", tc.verdict, fw.Verdict)
+			}
+			if buf.String() != tc.output {
+				t.Errorf("Unexpected output: wanted %q got %q", tc.output, buf.String())
+			}
+			if len(fw.Held) != tc.held {
+				t.Errorf("Unexpected held reasons: %q", fw.Held)
+			}
+		})
+	}
+}
+
+// Write each chunk then close the writer, returning what was written through
+func writeChunks(fw *filters.FilterWriter, chunks [][]byte) (string, error) {
+	buf := new(bytes.Buffer)
+	fw.Writer = buf
+	for _, chunk := range chunks {
+		n, err := fw.Write(chunk)
+		if err != nil {
+			return buf.String(), err
+		} else if n != len(chunk) {
+			return buf.String(), fmt.Errorf("short write: %d of %d bytes", n, len(chunk))
+		}
+	}
+	err := fw.Close()
+	return buf.String(), err
+}
+
+func TestFilterWriter(t *testing.T) {
+	words := filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x"}
+	reject := filters.WordFilter{Words: map[string]bool{"apple": true}, Action: filters.RejectWords}
+	fullWidth := []byte("ｍｏｏｐ")
+
+	cases := []struct {
+		name      string
+		chunks    [][]byte
+		chunkSize int
+		expected  string
+		err       bool
+	}{
+		{"Single Write", [][]byte{[]byte("meep moop")}, 0, "meep x", false},
+		{"Split Word", [][]byte{[]byte("meep mo"), []byte("op meep")}, 0, "meep x meep", false},
+		{"Split Rune", [][]byte{fullWidth[:4], fullWidth[4:]}, 0, "x", false},
+		{"Paragraphs", [][]byte{[]byte("moop\n"), []byte("\nmo"), []byte("op")}, 0, "x\n\nx", false},
+		{"Chunk Size", [][]byte{[]byte("moop moop moop")}, 6, "x x x", false},
+		{"Chunk Size Rune", [][]byte{fullWidth}, 5, "ｍｏｏｐ", false},
+		{"Split Reject", [][]byte{[]byte("an ap"), []byte("ple")}, 0, "", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fw := filters.NewFilterWriter(nil, words, reject)
+			fw.ChunkSize = tc.chunkSize
+			output, err := writeChunks(fw, tc.chunks)
+			if (err != nil) != tc.err {
+				t.Fatal("Unexpected error:", err)
+			}
+			if output != tc.expected {
+				t.Errorf("Unexpected output: wanted %q got %q", tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestFilterWriterErrors(t *testing.T) {
+	reject := filters.WordFilter{Words: map[string]bool{"apple": true}, Action: filters.RejectWords}
+	fw := filters.NewFilterWriter(new(bytes.Buffer), reject)
+
+	if _, err := fw.Write([]byte("an apple\n\n")); err == nil {
+		t.Fatal("Expected rejection")
+	}
+	if n, err := fw.Write([]byte("pie")); n != 0 || !errors.As(err, new(filters.RejectedError)) {
+		t.Errorf("Expected rejection to persist, got %d %v", n, err)
+	}
+	if err := fw.Close(); !errors.As(err, new(filters.RejectedError)) {
+		t.Errorf("Expected close to return the rejection, got %v", err)
+	}
+
+	fw = filters.NewFilterWriter(new(bytes.Buffer))
+	if err := fw.Close(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if _, err := fw.Write([]byte("pie")); err != filters.ErrWriterClosed {
+		t.Errorf("Expected write after close to fail, got %v", err)
+	}
+}
+
+// Records the text each call to Filter receives
+type recordFilter struct {
+	chunks *[][]byte
+}
+
+func (f recordFilter) Filter(p []byte) ([]byte, error) {
+	*f.chunks = append(*f.chunks, bytes.Clone(p))
+	return p, nil
+}
+
+// Split text into writes with lengths taken from splits
+func splitWrites(text []byte, splits []byte) [][]byte {
+	var chunks [][]byte
+	for i := 0; len(text) > 0; i++ {
+		n := len(text)
+		if i < len(splits) {
+			n = min(int(splits[i])%32+1, len(text))
+		}
+		chunks = append(chunks, text[:n])
+		text = text[n:]
+	}
+	return chunks
+}
+
+func FuzzFilterWriter(f *testing.F) {
+	f.Add("meep moop\n\nｍｏｏｐ a rotten  apple, m00p!", []byte{3, 1, 7})
+	f.Add("mail jp@jpappel.xyz\n\n\nor mo\nop", []byte{0, 0, 0, 0, 2})
+	f.Add("ｍｏｏｐ мооp ROTTEN\napple", []byte{1, 1, 1, 1, 1, 1, 1})
+	// a rune split across writes while the held text is past the chunk size
+	f.Add("0000000ｾ0", []byte{0, 6, 0})
+
+	pipeline := []filters.Filterer{
+		filters.WordFilter{Words: map[string]bool{"moop": true}, Replacement: "x", Leetspeak: true},
+		filters.NewPhraseFilter(map[string]bool{"rotten apple": true}, filters.WordFilter{Replacement: "y"}),
+		filters.AvailableFilters["email"],
+	}
+
+	f.Fuzz(func(t *testing.T, text string, splits []byte) {
+		if len(text) > filters.DefaultChunkSize {
+			t.Skip()
+		}
+
+		expected, expectedErr := writeChunks(filters.NewFilterWriter(nil, pipeline...), [][]byte{[]byte(text)})
+		output, err := writeChunks(filters.NewFilterWriter(nil, pipeline...), splitWrites([]byte(text), splits))
+		if (err != nil) != (expectedErr != nil) {
+			t.Fatalf("Different errors: wanted %v got %v", expectedErr, err)
+		} else if output != expected {
+			t.Fatalf("Output depends on write boundaries: wanted %q got %q", expected, output)
+		}
+
+		// small chunks are still split on rune boundaries
+		var chunks [][]byte
+		fw := filters.NewFilterWriter(nil, recordFilter{&chunks})
+		if len(splits) > 0 {
+			fw.ChunkSize = int(splits[0])%8 + 1
+		}
+		output, err = writeChunks(fw, splitWrites([]byte(text), splits))
+		if err != nil || output != text {
+			t.Fatalf("Expected text to pass through unchanged, got %q %v", output, err)
+		}
+		for _, chunk := range chunks {
+			if utf8.ValidString(text) && !utf8.Valid(chunk) {
+				t.Fatalf("Chunk %q splits a rune", chunk)
+			}
+		}
+	})
+}
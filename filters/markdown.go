@@ -10,23 +10,39 @@ import (
 // TODO: figure out how to make composable
 //       it might not make sense to make these composable
 type Converter interface {
-	Convert([]byte, io.Writer)
+	Convert([]byte, io.Writer) error
 }
 
 type MarkdownConverter struct {
-	goldmark.Markdown
+	md goldmark.Markdown
 }
 
-// TODO: add config for extensions
-//       * KaTex
-//       * mermaid?
-// TODO: write extension for adding penny classes to nodes
+// Markdown extensions which can be enabled by name
+var MarkdownExtensions = map[string]goldmark.Extender{
+	"table":         extension.Table,
+	"strikethrough": extension.Strikethrough,
+	"linkify":       extension.Linkify,
+	"tasklist":      extension.TaskList,
+	"math":          Math,
+	"mermaid":       Mermaid,
+}
+
+// Extensions for GitHub flavored markdown
+var DefaultMarkdownExtensions = []string{"table", "strikethrough", "linkify", "tasklist"}
 
-// create a markdown converter with sane defaults
-func NewMarkdownConverter() MarkdownConverter {
+// create a markdown converter with only the given extensions
+//
+// Raw html is never rendered and links are passed through the Sanitizer.
+func NewMarkdownConverter(extensions ...goldmark.Extender) MarkdownConverter {
 	md := goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithExtensions(Sanitizer),
+		goldmark.WithExtensions(extensions...),
 	)
 
 	return MarkdownConverter{md}
 }
+
+// Render markdown source as html
+func (c MarkdownConverter) Convert(source []byte, w io.Writer) error {
+	return c.md.Convert(source, w)
+}
@@ -0,0 +1,134 @@
+package filters_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark/extension"
+
+	"github.com/jpappel/penny/filters"
+)
+
+type MarkdownTestCase struct {
+	name     string
+	input    string
+	contains []string
+	excludes []string
+}
+
+func (tc MarkdownTestCase) Test(t *testing.T) {
+	tc.TestConverter(t, filters.NewMarkdownConverter(extension.GFM))
+}
+
+func (tc MarkdownTestCase) TestConverter(t *testing.T, converter filters.MarkdownConverter) {
+	buf := new(bytes.Buffer)
+	if err := converter.Convert([]byte(tc.input), buf); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	html := buf.String()
+	for _, s := range tc.contains {
+		if !strings.Contains(html, s) {
+			t.Errorf("Expected output to contain %q", s)
+		}
+	}
+	for _, s := range tc.excludes {
+		if strings.Contains(html, s) {
+			t.Errorf("Expected output not to contain %q", s)
+		}
+	}
+
+	if t.Failed() {
+		t.Log("Output:\n", html)
+	}
+}
+
+func TestMarkdownConverter(t *testing.T) {
+	cases := []MarkdownTestCase{
+		{"Emphasis", "some *apples*", []string{"<em>apples</em>"}, nil},
+		{"Strikethrough", "~~pears~~", []string{"<del>pears</del>"}, nil},
+		{"Table", "| a | b |\n|---|---|\n| 1 | 2 |", []string{"<table>", "<td>1</td>"}, nil},
+		{"Raw HTML Block", "<script>alert(1)</script>", nil, []string{"<script"}},
+		{"Inline HTML", "hello <img src=x onerror=alert(1)> there", []string{"hello", "there"}, []string{"<img", "onerror"}},
+		{"Link", "[site](https://example.com)", []string{`<a href="https://example.com" rel="nofollow ugc">site</a>`}, nil},
+		{"Relative Link", "[post](/posts/apples)", []string{`href="/posts/apples"`}, nil},
+		{"Mailto Link", "[mail](mailto:jp@jpappel.xyz)", []string{`href="mailto:jp@jpappel.xyz"`}, nil},
+		{"Autolink", "see https://example.com", []string{`<a href="https://example.com" rel="nofollow ugc">`}, nil},
+		{"Javascript Link", "[click](javascript:alert(1))", []string{"click"}, []string{"<a", "javascript"}},
+		{"Encoded Javascript Link", "[click](javascript&colon;alert(1))", []string{"click"}, []string{"<a", "javascript"}},
+		{"Data Link", "[click](data:text/html;base64,PHNjcmlwdD4=)", []string{"click"}, []string{"<a", "data:"}},
+		{"Nested Unsafe Link", "[**bold** text](vbscript:msgbox)", []string{"<strong>bold</strong> text"}, []string{"<a", "vbscript"}},
+		{"Image", "![apple](https://example.com/apple.png)", []string{`<img src="https://example.com/apple.png" alt="apple">`}, nil},
+		{"Unsafe Image", "![apple](javascript:alert(1))", []string{"apple"}, []string{"<img", "javascript"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, tc.Test)
+	}
+}
+
+func TestPennyClasses(t *testing.T) {
+	names := filters.DefaultClassNames
+	names.Link = "my-link"
+	converter := filters.NewMarkdownConverter(extension.GFM, filters.PennyClasses(names))
+
+	cases := []MarkdownTestCase{
+		{"Heading", "# Title", []string{`<h3 class="penny-heading">Title</h3>`}, []string{"<h1"}},
+		{"Second Heading", "## Subtitle", []string{`<h4 class="penny-heading">`}, []string{"<h2"}},
+		{"Deep Heading", "##### Deep", []string{`<h6 class="penny-heading">`}, []string{"<h7"}},
+		{"Fenced Code", "```go\nx := 1 < 2\n```", []string{`<pre class="penny-code"><code class="language-go">x := 1 &lt; 2` + "\n</code></pre>"}, nil},
+		{"Indented Code", "    <b>", []string{`<pre class="penny-code"><code>&lt;b&gt;`}, nil},
+		{"Blockquote", "> quoted", []string{`<blockquote class="penny-quote">`}, nil},
+		{"Link", "[site](https://example.com)", []string{`class="my-link"`, `rel="nofollow ugc"`}, nil},
+		{"Table", "| a |\n|---|\n| 1 |", []string{`<table class="penny-table">`}, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) { tc.TestConverter(t, converter) })
+	}
+}
+
+func TestMathAndMermaid(t *testing.T) {
+	converter := filters.NewMarkdownConverter(filters.Math, filters.Mermaid)
+
+	cases := []MarkdownTestCase{
+		{"Inline Math", "where $x_1 < y^2$ holds", []string{`where <span class="penny-math">\(x_1 &lt; y^2\)</span> holds`}, []string{"<em>"}},
+		{"Display Inline", "$$e^{i\\pi} = -1$$", []string{`<span class="penny-math penny-math-display">\[e^{i\pi} = -1\]</span>`}, nil},
+		{"Math Block", "$$\n\\int_0^1 x\\,dx\n$$", []string{"<div class=\"penny-math penny-math-display\">\\[\n\\int_0^1 x\\,dx\n\\]</div>"}, nil},
+		{"Prices", "apples are $5 and pears $10", []string{"apples are $5 and pears $10"}, []string{"penny-math"}},
+		{"Spaced Dollars", "a $ b $ c", []string{"a $ b $ c"}, []string{"penny-math"}},
+		{"Escaped Dollar", `\$x$`, nil, []string{"penny-math"}},
+		{"Math HTML", "$<script>$", []string{`\(&lt;script&gt;\)`}, []string{"<script"}},
+		{"Mermaid", "```mermaid\ngraph TD\n  A-->B\n```", []string{"<pre class=\"mermaid\">graph TD\n  A--&gt;B\n</pre>"}, []string{"<code"}},
+		{"Other Code", "```go\nfmt.Println()\n```", []string{`<code class="language-go">`}, []string{"mermaid"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) { tc.TestConverter(t, converter) })
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	cases := []struct {
+		url  string
+		safe bool
+	}{
+		{"https://example.com", true},
+		{"HTTP://example.com", true},
+		{"mailto:jp@jpappel.xyz", true},
+		{"/relative/path", true},
+		{"#anchor", true},
+		{"javascript:alert(1)", false},
+		{"JaVaScRiPt:alert(1)", false},
+		{"java\tscript:alert(1)", false},
+		{"data:text/html,hi", false},
+		{"file:///etc/passwd", false},
+	}
+
+	for _, tc := range cases {
+		if safe := filters.SafeURL([]byte(tc.url)); safe != tc.safe {
+			t.Errorf("SafeURL(%q): wanted %t got %t", tc.url, tc.safe, safe)
+		}
+	}
+}
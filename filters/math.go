@@ -0,0 +1,195 @@
+package filters
+
+import (
+	"bytes"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Goldmark extension for $inline$ and $$display$$ math
+//
+// Math is rendered as escaped TeX between \( \) or \[ \] delimiters for KaTeX to typeset in the browser.
+var Math goldmark.Extender = mathExtension{}
+
+var KindMath = ast.NewNodeKind("Math")
+var KindMathBlock = ast.NewNodeKind("MathBlock")
+
+// TeX inside a paragraph
+type MathNode struct {
+	ast.BaseInline
+	Value   []byte
+	Display bool
+}
+
+func (n *MathNode) Kind() ast.NodeKind {
+	return KindMath
+}
+
+func (n *MathNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Value": string(n.Value)}, nil)
+}
+
+// TeX between lines containing only $$
+type MathBlock struct {
+	ast.BaseBlock
+}
+
+func (n *MathBlock) Kind() ast.NodeKind {
+	return KindMathBlock
+}
+
+func (n *MathBlock) IsRaw() bool {
+	return true
+}
+
+func (n *MathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type mathExtension struct{}
+
+func (mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 700)),
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 150)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(mathRenderer{}, 500),
+	))
+}
+
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// Parse $...$ or $$...$$ on a single line
+//
+// Like pandoc, inline math cannot start after or end before a space and
+// cannot be followed by a digit, so prices like $5 and $10 stay text.
+func (mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	delim := []byte("$")
+	if bytes.HasPrefix(line, []byte("$$")) {
+		delim = []byte("$$")
+	}
+	display := len(delim) == 2
+
+	rest := line[len(delim):]
+	if len(rest) == 0 || (!display && unicode.IsSpace(rune(rest[0]))) {
+		return nil
+	}
+
+	for i := 0; i < len(rest); i++ {
+		if rest[i] == '\\' {
+			i++
+			continue
+		} else if !bytes.HasPrefix(rest[i:], delim) {
+			continue
+		}
+
+		if i == 0 {
+			return nil
+		} else if !display {
+			if unicode.IsSpace(rune(rest[i-1])) {
+				continue
+			} else if after := i + 1; after < len(rest) && rest[after] >= '0' && rest[after] <= '9' {
+				continue
+			}
+		}
+
+		start := segment.Start + len(delim)
+		value := block.Value(text.NewSegment(start, start+i))
+		block.Advance(len(delim) + i + len(delim))
+		return &MathNode{Value: bytes.Clone(value), Display: display}
+	}
+
+	return nil
+}
+
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) || !util.IsBlank(line[pos+2:]) {
+		return nil, parser.NoChildren
+	}
+
+	reader.AdvanceToEOL()
+	return &MathBlock{}, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, segment := reader.PeekLine()
+	if bytes.Equal(bytes.TrimSpace(line), []byte("$$")) {
+		reader.AdvanceToEOL()
+		return parser.Close
+	}
+
+	segment.ForceNewline = true
+	node.Lines().Append(segment)
+	reader.AdvanceToEOL()
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+type mathRenderer struct{}
+
+func (r mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMath, r.renderInline)
+	reg.Register(KindMathBlock, r.renderBlock)
+}
+
+func (r mathRenderer) renderInline(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*MathNode)
+	if n.Display {
+		_, _ = w.WriteString(`<span class="penny-math penny-math-display">\[`)
+		_, _ = w.Write(util.EscapeHTML(n.Value))
+		_, _ = w.WriteString(`\]</span>`)
+	} else {
+		_, _ = w.WriteString(`<span class="penny-math">\(`)
+		_, _ = w.Write(util.EscapeHTML(n.Value))
+		_, _ = w.WriteString(`\)</span>`)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r mathRenderer) renderBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		_, _ = w.WriteString("\\]</div>\n")
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString("<div class=\"penny-math penny-math-display\">\\[\n")
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		_, _ = w.Write(util.EscapeHTML(line.Value(source)))
+	}
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,83 @@
+package filters
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Goldmark extension rendering ```mermaid code blocks for mermaid.js to draw in the browser
+var Mermaid goldmark.Extender = mermaidExtension{}
+
+var KindMermaid = ast.NewNodeKind("Mermaid")
+
+// Diagram source from a mermaid code block
+type MermaidBlock struct {
+	ast.BaseBlock
+}
+
+func (n *MermaidBlock) Kind() ast.NodeKind {
+	return KindMermaid
+}
+
+func (n *MermaidBlock) IsRaw() bool {
+	return true
+}
+
+func (n *MermaidBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type mermaidExtension struct{}
+
+func (e mermaidExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(e, 200),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(e, 500),
+	))
+}
+
+// Replace mermaid code blocks with diagrams
+func (e mermaidExtension) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	var blocks []*ast.FencedCodeBlock
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if code, ok := n.(*ast.FencedCodeBlock); ok && entering {
+			if bytes.Equal(code.Language(reader.Source()), []byte("mermaid")) {
+				blocks = append(blocks, code)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, code := range blocks {
+		diagram := &MermaidBlock{}
+		diagram.SetLines(code.Lines())
+		code.Parent().ReplaceChild(code.Parent(), code, diagram)
+	}
+}
+
+func (e mermaidExtension) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMermaid, e.render)
+}
+
+func (e mermaidExtension) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		_, _ = w.WriteString("</pre>\n")
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString(`<pre class="mermaid">`)
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		_, _ = w.Write(util.EscapeHTML(line.Value(source)))
+	}
+	return ast.WalkContinue, nil
+}
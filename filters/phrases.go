@@ -0,0 +1,251 @@
+package filters
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Replaces, rejects or holds banned words and phrases, matching all of them in one pass
+//
+// Phrases are matched with an Aho–Corasick automaton over normalized text, so
+// "very  BAD" matches the phrase "very bad". Matches must start and end on word
+// boundaries unless the Substring option is set.
+type PhraseFilter struct {
+	options   WordFilter
+	automaton *automaton
+}
+
+// Create a filter for phrases, using the options of a WordFilter except its Words
+func NewPhraseFilter(phrases map[string]bool, options WordFilter) PhraseFilter {
+	options.Words = nil
+	normalized := make([][]rune, 0, len(phrases))
+	for phrase := range phrases {
+		var runes []rune
+		for _, nr := range normalizeText(phrase, options.Leetspeak) {
+			runes = append(runes, nr.r)
+		}
+		// drop spaces around the phrase left by collapsing whitespace
+		if start, end := trimSpaceRunes(runes); start < end {
+			normalized = append(normalized, runes[start:end])
+		}
+	}
+
+	return PhraseFilter{options, newAutomaton(normalized)}
+}
+
+func (f PhraseFilter) Filter(p []byte) ([]byte, error) {
+	if f.automaton == nil {
+		return p, nil
+	}
+
+	s := string(p)
+	text := normalizeText(s, f.options.Leetspeak)
+
+	type match struct{ start, end int }
+	var matches []match
+	state := int32(0)
+	for i, nr := range text {
+		state = f.automaton.step(state, nr.r)
+		for _, length := range f.automaton.nodes[state].out {
+			m := match{i + 1 - int(length), i + 1}
+			if f.options.Substring || (isBoundary(text, m.start-1) && isBoundary(text, m.end)) {
+				matches = append(matches, m)
+			}
+		}
+	}
+
+	if len(matches) == 0 || f.options.Action == HoldWords {
+		return p, nil
+	} else if f.options.Action == RejectWords {
+		return nil, ErrBannedWord
+	}
+
+	// keep the leftmost longest of any overlapping matches
+	slices.SortFunc(matches, func(a, b match) int {
+		if a.start != b.start {
+			return a.start - b.start
+		}
+		return b.end - a.end
+	})
+
+	result := strings.Builder{}
+	result.Grow(len(p))
+	written := 0
+	for _, m := range matches {
+		start, end := text[m.start].start, text[m.end-1].end
+		if start < written {
+			continue
+		}
+		result.WriteString(s[written:start])
+		result.WriteString(f.options.replacement(s[start:end]))
+		written = end
+	}
+	result.WriteString(s[written:])
+
+	return []byte(result.String()), nil
+}
+
+func (f PhraseFilter) Judge(p []byte) Result {
+	if f.options.Action != HoldWords {
+		filtered, err := f.Filter(p)
+		return filterResult(p, filtered, err)
+	}
+
+	check := f
+	check.options.Action = RejectWords
+	if _, err := check.Filter(p); err == ErrBannedWord {
+		return Result{Verdict: Hold, Text: p, Reason: err.Error()}
+	}
+	return Result{Verdict: Allow, Text: p}
+}
+
+// A rune of normalized text and the bytes of the original text it came from
+type normRune struct {
+	r          rune
+	start, end int
+}
+
+// Normalize text like NormalizeWord, collapsing runs of whitespace into a single space
+func normalizeText(s string, leet bool) []normRune {
+	text := make([]normRune, 0, len(s))
+	// only created once text outside of ascii is seen
+	var fold *cases.Caser
+	for i, r := range s {
+		end := i + utf8.RuneLen(r)
+		if r == utf8.RuneError {
+			end = i + 1
+		}
+
+		switch {
+		case unicode.IsSpace(r):
+			if len(text) > 0 && text[len(text)-1].r == ' ' {
+				text[len(text)-1].end = end
+			} else {
+				text = append(text, normRune{' ', i, end})
+			}
+			continue
+		case r < utf8.RuneSelf:
+			if 'A' <= r && r <= 'Z' {
+				r += 'a' - 'A'
+			} else if latin, ok := leetspeak[r]; ok && leet {
+				r = latin
+			}
+			text = append(text, normRune{r, i, end})
+			continue
+		}
+
+		if fold == nil {
+			caser := cases.Fold()
+			fold = &caser
+		}
+		for _, nr := range fold.String(norm.NFKC.String(string(r))) {
+			if latin, ok := confusables[nr]; ok {
+				nr = latin
+			} else if latin, ok := leetspeak[nr]; ok && leet {
+				nr = latin
+			}
+			text = append(text, normRune{nr, i, end})
+		}
+	}
+	return text
+}
+
+func trimSpaceRunes(runes []rune) (int, int) {
+	start, end := 0, len(runes)
+	for start < end && runes[start] == ' ' {
+		start++
+	}
+	for end > start && runes[end-1] == ' ' {
+		end--
+	}
+	return start, end
+}
+
+// Report if a position in normalized text is outside of a word
+func isBoundary(text []normRune, i int) bool {
+	if i < 0 || i >= len(text) {
+		return true
+	}
+	r := text[i].r
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// An Aho–Corasick automaton over runes
+type automaton struct {
+	nodes []acNode
+}
+
+type acNode struct {
+	next map[rune]int32
+	fail int32
+	// lengths of the patterns ending at this node, including those of its fail links
+	out []int32
+}
+
+func newAutomaton(patterns [][]rune) *automaton {
+	a := &automaton{nodes: []acNode{{next: make(map[rune]int32)}}}
+
+	for _, pattern := range patterns {
+		state := int32(0)
+		for _, r := range pattern {
+			next, ok := a.nodes[state].next[r]
+			if !ok {
+				next = int32(len(a.nodes))
+				a.nodes = append(a.nodes, acNode{next: make(map[rune]int32)})
+				a.nodes[state].next[r] = next
+			}
+			state = next
+		}
+		if !slices.Contains(a.nodes[state].out, int32(len(pattern))) {
+			a.nodes[state].out = append(a.nodes[state].out, int32(len(pattern)))
+		}
+	}
+
+	// breadth first so fail links always point to finished nodes
+	queue := make([]int32, 0, len(a.nodes))
+	for _, child := range a.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		for r, child := range a.nodes[state].next {
+			fail := a.nodes[state].fail
+			for fail != 0 {
+				if _, ok := a.nodes[fail].next[r]; ok {
+					break
+				}
+				fail = a.nodes[fail].fail
+			}
+			if next, ok := a.nodes[fail].next[r]; ok && next != child {
+				fail = next
+			} else {
+				fail = 0
+			}
+
+			a.nodes[child].fail = fail
+			a.nodes[child].out = append(a.nodes[child].out, a.nodes[fail].out...)
+			queue = append(queue, child)
+		}
+	}
+
+	return a
+}
+
+// Follow a rune from a state, falling back along fail links until it can be followed
+func (a *automaton) step(state int32, r rune) int32 {
+	for {
+		if next, ok := a.nodes[state].next[r]; ok {
+			return next
+		} else if state == 0 {
+			return 0
+		}
+		state = a.nodes[state].fail
+	}
+}
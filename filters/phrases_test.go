@@ -0,0 +1,131 @@
+package filters_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/jpappel/penny/filters"
+)
+
+func TestPhraseFilter(t *testing.T) {
+	phrases := map[string]bool{"moop": true, "very  BAD idea": true, "bad": true, "bad idea": true}
+	replace := filters.NewPhraseFilter(phrases, filters.WordFilter{Replacement: "x"})
+
+	cases := []FiltererTestCase{
+		{"Empty", filters.NewPhraseFilter(nil, filters.WordFilter{}), []byte("meep moop"), []byte("meep moop"), nil},
+		{"Word", replace, []byte("meep moop meep"), []byte("meep x meep"), nil},
+		{"Phrase", replace, []byte("a very bad idea"), []byte("a x"), nil},
+		{"Phrase Whitespace", replace, []byte("a Very\n  BAD\tidea!"), []byte("a x!"), nil},
+		{"Longest Match", replace, []byte("a bad idea, bad"), []byte("a x, x"), nil},
+		{"Boundaries", replace, []byte("moops smoop badly"), []byte("moops smoop badly"), nil},
+		{"Punctuation", replace, []byte(`"moop," (bad)`), []byte(`"x," (x)`), nil},
+		{"Normalized", replace, []byte("ＭＯＯＰ mоор"), []byte("x x"), nil},
+		{"Substring", filters.NewPhraseFilter(phrases, filters.WordFilter{Replacement: "x", Substring: true}), []byte("smoops"), []byte("sxs"), nil},
+		{"Leetspeak", filters.NewPhraseFilter(phrases, filters.WordFilter{Replacement: "x", Leetspeak: true}), []byte("m00p b4d"), []byte("x x"), nil},
+		{"Preserve Length", filters.NewPhraseFilter(phrases, filters.WordFilter{PreserveLength: true}), []byte("bad idea"), []byte("********"), nil},
+		{"Reject", filters.NewPhraseFilter(phrases, filters.WordFilter{Action: filters.RejectWords}), []byte("not a bad  idea"), nil, filters.ErrBannedWord},
+		{"Overlapping Patterns", filters.NewPhraseFilter(map[string]bool{"he": true, "she": true, "hers": true}, filters.WordFilter{Replacement: "x", Substring: true}), []byte("ushers"), []byte("uxrs"), nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, tc.Test)
+	}
+}
+
+// Check the phrase filter agrees with the word filter on single words
+func TestPhraseFilterMatchesWordFilter(t *testing.T) {
+	words := map[string]bool{}
+	for i := range 100 {
+		words[fmt.Sprintf("word%d", i)] = true
+	}
+	options := filters.WordFilter{Words: words, Replacement: "x"}
+	phrases := filters.NewPhraseFilter(words, options)
+
+	rng := rand.New(rand.NewSource(1))
+	for range 100 {
+		var b strings.Builder
+		for range 50 {
+			fmt.Fprintf(&b, "Word%d, ", rng.Intn(200))
+		}
+		input := []byte(b.String())
+
+		expected, _ := options.Filter(input)
+		result, _ := phrases.Filter(input)
+		if string(expected) != string(result) {
+			t.Fatalf("Filters disagree on %q:\nword:   %q\nphrase: %q", input, expected, result)
+		}
+	}
+}
+
+// A blocklist of n words and a comment of about size bytes containing some of them
+func benchmarkInput(n int, size int) (map[string]bool, []byte) {
+	rng := rand.New(rand.NewSource(1))
+	words := make(map[string]bool, n)
+	for i := range n {
+		words[fmt.Sprintf("banned%d", i)] = true
+	}
+
+	var b strings.Builder
+	for b.Len() < size {
+		if rng.Intn(20) == 0 {
+			fmt.Fprintf(&b, "banned%d ", rng.Intn(n))
+		} else {
+			fmt.Fprintf(&b, "word%d ", rng.Intn(1000))
+		}
+	}
+	return words, []byte(b.String())
+}
+
+func BenchmarkWordFilter(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		words, comment := benchmarkInput(n, 1<<14)
+		f := filters.WordFilter{Words: words, Replacement: "*"}
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.SetBytes(int64(len(comment)))
+			for range b.N {
+				f.Filter(comment)
+			}
+		})
+	}
+}
+
+func BenchmarkWordFilterSubstring(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		words, comment := benchmarkInput(n, 1<<14)
+		f := filters.WordFilter{Words: words, Replacement: "*", Substring: true}
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.SetBytes(int64(len(comment)))
+			for range b.N {
+				f.Filter(comment)
+			}
+		})
+	}
+}
+
+func BenchmarkPhraseFilter(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		words, comment := benchmarkInput(n, 1<<14)
+		f := filters.NewPhraseFilter(words, filters.WordFilter{Replacement: "*"})
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.SetBytes(int64(len(comment)))
+			for range b.N {
+				f.Filter(comment)
+			}
+		})
+	}
+}
+
+func BenchmarkPhraseFilterSubstring(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		words, comment := benchmarkInput(n, 1<<14)
+		f := filters.NewPhraseFilter(words, filters.WordFilter{Replacement: "*", Substring: true})
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.SetBytes(int64(len(comment)))
+			for range b.N {
+				f.Filter(comment)
+			}
+		})
+	}
+}
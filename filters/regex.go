@@ -0,0 +1,121 @@
+package filters
+
+import (
+	"net/netip"
+	"regexp"
+)
+
+// A named regular expression and the template its matches are replaced with
+//
+// Replacement is expanded like regexp.Expand, so $1 and ${name} insert submatches
+// and $$ inserts a literal $.
+type RegexPattern struct {
+	Name        string
+	Regexp      *regexp.Regexp
+	Replacement string
+	// reports if a match should be replaced, every match is replaced when nil
+	Validate func(match []byte) bool
+}
+
+// Replaces text matching any of its patterns, applying them in order
+type RegexFilter struct {
+	Patterns []RegexPattern
+}
+
+// Create a pattern, failing if expr does not compile
+func NewRegexPattern(name string, expr string, replacement string) (RegexPattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return RegexPattern{}, err
+	}
+	return RegexPattern{Name: name, Regexp: re, Replacement: replacement}, nil
+}
+
+func (f RegexFilter) Filter(p []byte) ([]byte, error) {
+	for _, pattern := range f.Patterns {
+		p = pattern.replace(p)
+	}
+	return p, nil
+}
+
+// Replace every valid match, returning src itself when nothing matched
+func (pattern RegexPattern) replace(src []byte) []byte {
+	var result []byte
+	written := 0
+	for _, match := range pattern.Regexp.FindAllSubmatchIndex(src, -1) {
+		if pattern.Validate != nil && !pattern.Validate(src[match[0]:match[1]]) {
+			continue
+		}
+		if result == nil {
+			result = make([]byte, 0, len(src))
+		}
+		result = append(result, src[written:match[0]]...)
+		result = pattern.Regexp.Expand(result, []byte(pattern.Replacement), src, match)
+		written = match[1]
+	}
+
+	if result == nil {
+		return src
+	}
+	return append(result, src[written:]...)
+}
+
+// Built in patterns for redacting personal data
+var RegexPresets = map[string]RegexPattern{
+	"email": {
+		Name:        "email",
+		Regexp:      regexp.MustCompile(`(?i)\b[a-z0-9._%+\-]+@[a-z0-9\-]+(?:\.[a-z0-9\-]+)*\.[a-z]{2,}\b`),
+		Replacement: "[email]",
+	},
+	"phone": {
+		Name:        "phone",
+		Regexp:      regexp.MustCompile(`(?:\+\d{1,3}[ .\-]?(?:\(\d{3}\)|\d{3})|\(\d{3}\)|\b\d{3})[ .\-]?\d{3}[ .\-]?\d{4}\b`),
+		Replacement: "[phone]",
+	},
+	"credit_card": {
+		Name:        "credit_card",
+		Regexp:      regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`),
+		Replacement: "[card]",
+		Validate:    Luhn,
+	},
+	"ip": {
+		Name:        "ip",
+		Regexp:      regexp.MustCompile(`(?i)\b(?:\d{1,3}(?:\.\d{1,3}){3}|[0-9a-f]{1,4}(?::{1,2}[0-9a-f]{1,4}){1,7})\b`),
+		Replacement: "[ip]",
+		Validate: func(match []byte) bool {
+			_, err := netip.ParseAddr(string(match))
+			return err == nil
+		},
+	},
+}
+
+// Report if the digits in b pass the Luhn checksum used by card numbers
+//
+// Spaces and dashes are ignored, any other non digit fails the check.
+func Luhn(b []byte) bool {
+	sum, digits := 0, 0
+	for i := len(b) - 1; i >= 0; i-- {
+		c := b[i]
+		if c == ' ' || c == '-' {
+			continue
+		} else if c < '0' || c > '9' {
+			return false
+		}
+
+		d := int(c - '0')
+		if digits%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits > 1 && sum%10 == 0
+}
+
+func init() {
+	for name, pattern := range RegexPresets {
+		AvailableFilters[name] = RegexFilter{[]RegexPattern{pattern}}
+	}
+}
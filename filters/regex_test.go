@@ -0,0 +1,59 @@
+package filters_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/jpappel/penny/filters"
+)
+
+func TestRegexFilter(t *testing.T) {
+	preset := func(name string) filters.Filterer {
+		return filters.AvailableFilters[name]
+	}
+	orders := filters.RegexFilter{Patterns: []filters.RegexPattern{{
+		Name:        "order",
+		Regexp:      regexp.MustCompile(`ORD-(?P<id>\d+)`),
+		Replacement: "order #${id}",
+	}}}
+
+	cases := []FiltererTestCase{
+		{"Template", orders, []byte("see ORD-42 and ORD-7."), []byte("see order #42 and order #7."), nil},
+		{"No Match", orders, []byte("nothing here"), []byte("nothing here"), nil},
+		{"Email", preset("email"), []byte("mail jp@jpappel.xyz or First.Last+tag@mail.example.co.uk!"), []byte("mail [email] or [email]!"), nil},
+		{"Not Email", preset("email"), []byte("@handle and a@b"), []byte("@handle and a@b"), nil},
+		{"Phone", preset("phone"), []byte("call 555-123-4567, (555) 123 4567 or +1 555.123.4567"), []byte("call [phone], [phone] or [phone]"), nil},
+		{"Country Code", preset("phone"), []byte("+15551234567"), []byte("[phone]"), nil},
+		{"Long Number", preset("phone"), []byte("12345678901234"), []byte("12345678901234"), nil},
+		{"Credit Card", preset("credit_card"), []byte("card 4111 1111 1111 1111 or 4111-1111-1111-1111"), []byte("card [card] or [card]"), nil},
+		{"Failed Luhn", preset("credit_card"), []byte("order 4111 1111 1111 1112"), []byte("order 4111 1111 1111 1112"), nil},
+		{"IPv4", preset("ip"), []byte("from 192.168.0.1:8080"), []byte("from [ip]:8080"), nil},
+		{"IPv6", preset("ip"), []byte("from 2001:db8::1 today"), []byte("from [ip] today"), nil},
+		{"Not IP", preset("ip"), []byte("at 12:30:45 with 999.1.1.1 and std::vector"), []byte("at 12:30:45 with 999.1.1.1 and std::vector"), nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, tc.Test)
+	}
+}
+
+func TestLuhn(t *testing.T) {
+	cases := []struct {
+		number string
+		valid  bool
+	}{
+		{"4111111111111111", true},
+		{"4111 1111 1111 1111", true},
+		{"5500-0000-0000-0004", true},
+		{"79927398713", true},
+		{"79927398710", false},
+		{"4111x111", false},
+		{"0", false},
+	}
+
+	for _, tc := range cases {
+		if valid := filters.Luhn([]byte(tc.number)); valid != tc.valid {
+			t.Errorf("Luhn(%q): wanted %t got %t", tc.number, tc.valid, valid)
+		}
+	}
+}
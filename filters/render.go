@@ -0,0 +1,82 @@
+package filters
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"io"
+	"strings"
+)
+
+// Turns comment source into the html shown to readers
+type Renderer struct {
+	Filters []Filterer
+	// renders the filtered text, plain text paragraphs are used when nil
+	Markdown Converter
+}
+
+// A comment rendered as html and what the filters decided to do with it
+type Rendering struct {
+	HTML    string
+	Verdict Verdict
+	// why the comment was held or rejected
+	Reason string
+}
+
+// Filter and render comment source as html, a rejected comment returns a RejectedError
+func (r Renderer) Render(source string) (string, error) {
+	rendering, err := r.Review(source)
+	if err != nil {
+		return "", err
+	} else if rendering.Verdict == Reject {
+		return "", RejectedError{rendering.Reason}
+	}
+	return rendering.HTML, nil
+}
+
+// Filter and render comment source, reporting whether it should be held or rejected
+//
+// Rejected comments are not rendered, errors are only returned when rendering fails.
+func (r Renderer) Review(source string) (Rendering, error) {
+	filtered := new(bytes.Buffer)
+	fw := NewFilterWriter(filtered, r.Filters...)
+	_, err := io.WriteString(fw, source)
+	if err == nil {
+		err = fw.Close()
+	}
+	if err != nil {
+		var rejected RejectedError
+		if errors.As(err, &rejected) {
+			return Rendering{Verdict: Reject, Reason: rejected.Reason}, nil
+		}
+		return Rendering{}, err
+	}
+
+	rendering := Rendering{Verdict: fw.Verdict, Reason: strings.Join(fw.Held, "; ")}
+	if r.Markdown == nil {
+		rendering.HTML = plainHTML(filtered.String())
+		return rendering, nil
+	}
+
+	buf := new(bytes.Buffer)
+	if err := r.Markdown.Convert(filtered.Bytes(), buf); err != nil {
+		return Rendering{}, err
+	}
+	rendering.HTML = buf.String()
+	return rendering, nil
+}
+
+// Escape text, splitting it into paragraphs on blank lines
+func plainHTML(text string) string {
+	var b strings.Builder
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		b.WriteString(html.EscapeString(paragraph))
+		b.WriteString("</p>\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package filters
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Url schemes links and images may use, relative urls are always allowed
+var SafeSchemes = []string{"http", "https", "mailto"}
+
+// rel attribute added to every link in a comment
+const linkRel = "nofollow ugc"
+
+// Goldmark extension which strips raw html and unsafe links from comments
+var Sanitizer goldmark.Extender = sanitizer{}
+
+type sanitizer struct{}
+
+func (sanitizer) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(sanitizer{}, 0),
+	))
+}
+
+// Report if a link destination is relative or uses a safe scheme
+func SafeURL(dest []byte) bool {
+	// entities are resolved when the link is rendered, so check the url a browser will see
+	dest = util.ResolveEntityNames(util.ResolveNumericReferences(dest))
+	u, err := url.Parse(string(bytes.TrimSpace(dest)))
+	if err != nil {
+		return false
+	} else if u.Scheme == "" {
+		return true
+	}
+
+	for _, scheme := range SafeSchemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sanitizer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var unsafe []ast.Node
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch n := n.(type) {
+		case *ast.RawHTML, *ast.HTMLBlock:
+			unsafe = append(unsafe, n)
+			return ast.WalkSkipChildren, nil
+		case *ast.Link:
+			if !SafeURL(n.Destination) {
+				unsafe = append(unsafe, n)
+			}
+			n.SetAttributeString("rel", []byte(linkRel))
+		case *ast.AutoLink:
+			if n.AutoLinkType == ast.AutoLinkURL && !SafeURL(n.URL(source)) {
+				unsafe = append(unsafe, n)
+			}
+			n.SetAttributeString("rel", []byte(linkRel))
+		case *ast.Image:
+			if !SafeURL(n.Destination) {
+				unsafe = append(unsafe, n)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, n := range unsafe {
+		parent := n.Parent()
+		if parent == nil {
+			continue
+		}
+
+		switch n := n.(type) {
+		case *ast.Link:
+			// keep the link text without the link
+			for c := n.FirstChild(); c != nil; {
+				next := c.NextSibling()
+				parent.InsertBefore(parent, n, c)
+				c = next
+			}
+		case *ast.Image:
+			parent.InsertBefore(parent, n, ast.NewString(plainText(n, source)))
+		case *ast.AutoLink:
+			parent.InsertBefore(parent, n, ast.NewString(n.Label(source)))
+		}
+		parent.RemoveChild(parent, n)
+	}
+}
+
+// Get the text inside a node, ignoring any formatting
+func plainText(n ast.Node, source []byte) []byte {
+	var buf bytes.Buffer
+	ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			buf.Write(n.Value(source))
+		case *ast.String:
+			buf.Write(n.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return buf.Bytes()
+}
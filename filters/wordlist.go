@@ -0,0 +1,141 @@
+package filters
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often a word list file is checked for changes
+const wordListCheckInterval = time.Second
+
+// A WordFilter or PhraseFilter whose words are read from a file and reloaded when it changes
+//
+// The file has one word or phrase per line, blank lines and lines starting with # are ignored.
+type WordListFilter struct {
+	path string
+	// options for the filter, its words come from the file
+	options WordFilter
+	phrases bool
+
+	mu        sync.RWMutex
+	filter    Filterer
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// Create a filter from a word list file, failing if it cannot be read
+func NewWordListFilter(path string, options WordFilter) (*WordListFilter, error) {
+	options.Words = nil
+	f := &WordListFilter{path: path, options: options}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Create a PhraseFilter from a word list file, failing if it cannot be read
+func NewPhraseListFilter(path string, options WordFilter) (*WordListFilter, error) {
+	options.Words = nil
+	f := &WordListFilter{path: path, options: options, phrases: true}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Read a word list, one word per line
+func ReadWordList(r io.Reader) (map[string]bool, error) {
+	words := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || word[0] == '#' {
+			continue
+		}
+		words[word] = true
+	}
+	return words, scanner.Err()
+}
+
+// Read the word list file again
+func (f *WordListFilter) Reload() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	words, err := ReadWordList(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.phrases {
+		f.filter = NewPhraseFilter(words, f.options)
+	} else {
+		filter := f.options
+		filter.Words = NormalizeWords(words, f.options.Leetspeak)
+		f.filter = filter
+	}
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	f.lastCheck = time.Now()
+	return nil
+}
+
+// Reload the word list if the file changed since it was last read
+func (f *WordListFilter) reloadIfChanged() {
+	f.mu.Lock()
+	if time.Since(f.lastCheck) < wordListCheckInterval {
+		f.mu.Unlock()
+		return
+	}
+	f.lastCheck = time.Now()
+	modTime, size := f.modTime, f.size
+	f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		slog.Warn("Unable to check word list, keeping previous words", slog.String("path", f.path), slog.Any("error", err))
+		return
+	} else if info.ModTime().Equal(modTime) && info.Size() == size {
+		return
+	}
+
+	if err := f.Reload(); err != nil {
+		slog.Warn("Unable to reload word list, keeping previous words", slog.String("path", f.path), slog.Any("error", err))
+		return
+	}
+	slog.Info("Reloaded word list", slog.String("path", f.path))
+}
+
+func (f *WordListFilter) Filter(p []byte) ([]byte, error) {
+	return f.current().Filter(p)
+}
+
+func (f *WordListFilter) Judge(p []byte) Result {
+	return Apply(f.current(), p)
+}
+
+// Get the filter for the latest words in the file
+func (f *WordListFilter) current() Filterer {
+	f.reloadIfChanged()
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.filter
+}
@@ -0,0 +1,65 @@
+package filters_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpappel/penny/filters"
+)
+
+func TestReadWordList(t *testing.T) {
+	words, err := filters.ReadWordList(strings.NewReader("# banned\napple\n\n  pear  \n#plum\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(words) != 2 || !words["apple"] || !words["pear"] {
+		t.Error("Unexpected words:", words)
+	}
+}
+
+func TestWordListFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("apple\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := filters.NewWordListFilter(path, filters.WordFilter{Replacement: "*"})
+	if err != nil {
+		t.Fatal("Failed to create filter:", err)
+	}
+	if out, _ := f.Filter([]byte("apple pear")); string(out) != "* pear" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+
+	if err := os.WriteFile(path, []byte("apple\npear\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// make the change visible even on filesystems with coarse modification times
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	// changes are picked up on the first use after the file is next checked
+	time.Sleep(1100 * time.Millisecond)
+	if out, _ := f.Filter([]byte("apple pear")); string(out) != "* *" {
+		t.Errorf("Expected reloaded words to be filtered, got %q", out)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reload(); err == nil {
+		t.Error("Expected an error reloading a missing file")
+	}
+	if out, _ := f.Filter([]byte("apple pear")); string(out) != "* *" {
+		t.Errorf("Expected previous words to be kept, got %q", out)
+	}
+
+	if _, err := filters.NewWordListFilter(path, filters.WordFilter{Replacement: "*"}); err == nil {
+		t.Error("Expected an error for a missing word list")
+	}
+}
@@ -0,0 +1,197 @@
+package filters
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// What a WordFilter does when it finds a banned word
+type WordAction int
+
+const (
+	ReplaceWords WordAction = iota
+	RejectWords
+	// leave the text unchanged and hold the comment for moderation
+	HoldWords
+)
+
+var ErrBannedWord error = errors.New("Comment contains a banned word")
+
+// Replaces, rejects or holds comments with banned words
+//
+// Words are compared after NormalizeWord, so the keys of Words should be normalized
+// the same way. Punctuation around a word is ignored when matching and kept in the output.
+type WordFilter struct {
+	Words       map[string]bool
+	Replacement string
+	Action      WordAction
+	// match words appearing anywhere inside a token
+	Substring bool
+	// match digits and symbols standing in for letters, like "4ppl3"
+	Leetspeak bool
+	// repeat the first rune of Replacement, or *, once per rune of the replaced word
+	PreserveLength bool
+}
+
+// Letters from other scripts which look like latin letters
+var confusables = map[rune]rune{
+	// cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p',
+	'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd',
+	// greek
+	'α': 'a', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u',
+}
+
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's',
+}
+
+// Normalize a word for comparison
+//
+// Words are NFKC normalized, case folded and have look alike letters replaced with latin ones.
+func NormalizeWord(word string, leet bool) string {
+	if !isASCII(word) {
+		word = cases.Fold().String(norm.NFKC.String(word))
+	} else {
+		word = strings.ToLower(word)
+	}
+	return strings.Map(func(r rune) rune {
+		if latin, ok := confusables[r]; ok {
+			return latin
+		} else if latin, ok := leetspeak[r]; ok && leet {
+			return latin
+		}
+		return r
+	}, word)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Normalize every word in a list
+func NormalizeWords(words map[string]bool, leet bool) map[string]bool {
+	normalized := make(map[string]bool, len(words))
+	for word := range words {
+		if word = NormalizeWord(word, leet); word != "" {
+			normalized[word] = true
+		}
+	}
+	return normalized
+}
+
+func (f WordFilter) Filter(p []byte) ([]byte, error) {
+	result := strings.Builder{}
+	result.Grow(len(p))
+
+	s := string(p)
+	start := -1
+	for i, r := range s {
+		if !unicode.IsSpace(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			if err := f.filterToken(&result, s[start:i]); err != nil {
+				return nil, err
+			}
+			start = -1
+		}
+		result.WriteRune(r)
+	}
+
+	if start >= 0 {
+		if err := f.filterToken(&result, s[start:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return []byte(result.String()), nil
+}
+
+func (f WordFilter) Judge(p []byte) Result {
+	if f.Action != HoldWords {
+		filtered, err := f.Filter(p)
+		return filterResult(p, filtered, err)
+	}
+
+	check := f
+	check.Action = RejectWords
+	if _, err := check.Filter(p); err == ErrBannedWord {
+		return Result{Verdict: Hold, Text: p, Reason: err.Error()}
+	}
+	return Result{Verdict: Allow, Text: p}
+}
+
+// Write a whitespace separated token, replacing it if it is banned
+func (f WordFilter) filterToken(result *strings.Builder, token string) error {
+	core := strings.TrimFunc(token, f.isPunct)
+	if core == "" {
+		result.WriteString(token)
+		return nil
+	}
+
+	if !f.matches(NormalizeWord(core, f.Leetspeak)) {
+		result.WriteString(token)
+		return nil
+	} else if f.Action == RejectWords {
+		return ErrBannedWord
+	} else if f.Action == HoldWords {
+		result.WriteString(token)
+		return nil
+	}
+
+	lead := strings.Index(token, core)
+	result.WriteString(token[:lead])
+	result.WriteString(f.replacement(core))
+	result.WriteString(token[lead+len(core):])
+	return nil
+}
+
+// Report if a rune around a word is ignored when matching
+func (f WordFilter) isPunct(r rune) bool {
+	if _, ok := leetspeak[r]; ok && f.Leetspeak {
+		return false
+	}
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func (f WordFilter) matches(word string) bool {
+	if f.Words[word] {
+		return true
+	} else if !f.Substring {
+		return false
+	}
+
+	for banned := range f.Words {
+		if strings.Contains(word, banned) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f WordFilter) replacement(word string) string {
+	if !f.PreserveLength {
+		return f.Replacement
+	}
+
+	r, _ := utf8.DecodeRuneInString(f.Replacement)
+	if r == utf8.RuneError {
+		r = '*'
+	}
+	return strings.Repeat(string(r), utf8.RuneCountInString(word))
+}
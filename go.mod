@@ -4,6 +4,7 @@ go 1.23.4
 
 require (
 	github.com/tursodatabase/go-libsql v0.0.0-20241221181756-6121e81fbf92
+	github.com/yuin/goldmark v1.8.6
 	golang.org/x/oauth2 v0.25.0
 	golang.org/x/text v0.21.0
 )
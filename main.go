@@ -1,133 +1,91 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jpappel/penny/api"
+	"github.com/jpappel/penny/config"
 	"github.com/jpappel/penny/data"
-	"github.com/jpappel/penny/text"
-	"golang.org/x/oauth2"
 )
 
-type Config struct {
-	Host           string   `json:"hostname"`
-	Port           int      `json:"port"`
-	BaseUrl        string   `json:"base_url"`
-	RenderMD       bool     `json:"render_markdown"`
-	Providers      []string `json:"providers"`
-	EnvFilename    string   `json:"env_file"`
-	EnabledFilters []string `json:"filters"`
-	filters        []text.Filterer
-	oauthConfigs   map[string]oauth2.Config
-}
-
-// Set env vars to values in a file
-func readEnvfile(filename string) error {
-	envFile, err := os.Open(filename)
-	if err != nil {
-		slog.Warn(fmt.Sprint("Unable to open environment file", filename))
-		return err
+func main() {
+	config, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	defer envFile.Close()
 
-	buf, err := io.ReadAll(envFile)
+	renderer, err := config.Renderer()
 	if err != nil {
-		slog.Error("Error occured while reading enviornment file")
-		return err
+		slog.Error("Invalid filter", slog.Any("error", err))
+		os.Exit(1)
 	}
 
-	if len(buf) > 2<<16 {
-		slog.Warn("Environment file is larger than expected")
+	if len(args) > 0 && args[0] == "migrate" {
+		os.Exit(runMigrate(config.DbFile, args[1:]))
+	} else if len(args) > 0 && args[0] == "rerender" {
+		os.Exit(runRerender(config.DbFile, renderer, args[1:]))
+	} else if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "Unknown command:", args[0])
+		os.Exit(2)
 	}
-
-	for _, line := range strings.Split(string(buf), "\n") {
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		left := strings.TrimSpace(parts[0])
-		if left[0] == '#' {
-			continue
-		}
-
-		os.Setenv(left, parts[1])
-	}
-
-	return nil
-}
-
-// Parse a config file into a struct
-func parseConfig(filename string) Config {
-	cfgFile, err := os.Open(filename)
-	if err != nil {
-		slog.Error("Unable to open config file")
-		panic(err)
-	}
-
-	buf, err := io.ReadAll(cfgFile)
+	providers, err := config.OAuthProviders()
 	if err != nil {
-		slog.Error("Error occured while reading config file")
-		panic(err)
+		slog.Error("Invalid provider", slog.Any("error", err))
+		os.Exit(1)
 	}
-	cfgFile.Close()
 
-	cfg := Config{}
-	if err := json.Unmarshal(buf, &cfg); err != nil {
-		slog.Error("Unable to parse config file")
-		panic(err)
-	}
+	pdb := data.NewPool(config.DbFile, config.DbPool)
+	server := api.NewServer(pdb, api.ServerConfig{
+		BaseUrl:   config.BaseUrl,
+		Renderer:  renderer,
+		Providers: providers,
+
+		SessionSecret:   config.SessionSecret,
+		SessionLifetime: time.Duration(config.Session.Lifetime) * time.Second,
+		SessionRotate:   time.Duration(config.Session.Rotate) * time.Second,
+		SecureCookies:   strings.HasPrefix(config.PublicUrl, "https://"),
+		TrustedOrigins:  config.TrustedOrigins,
+		Admins:          config.Admins,
+		EditWindow:      time.Duration(config.EditWindow) * time.Second,
+	})
+	defer server.Close()
 
-	if cfg.EnvFilename == "" {
-		cfg.EnvFilename = ".env"
-	}
+	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	httpServer := &http.Server{Addr: addr, Handler: server}
 
-	if err = readEnvfile(cfg.EnvFilename); err != nil {
-		panic(err)
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	keys := []string{"GOOGLE_SECRET"}
-	cfg.oauthConfigs = make(map[string]oauth2.Config)
+	go purgeDeleted(ctx, pdb, time.Duration(config.PurgeAfter)*time.Second)
 
-	for _, key := range keys {
-		val, ok := os.LookupEnv(key)
-		if !ok {
-			continue
+	go func() {
+		slog.Info(fmt.Sprintf("Starting Penny on %s", addr))
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			slog.Error("Server stopped unexpectedly", slog.Any("error", err))
+			stop()
 		}
-		// TODO: parse env vars
-		cfg.oauthConfigs[val] = oauth2.Config{}
-	}
+	}()
 
-	for _, filterName := range cfg.EnabledFilters {
-		filter, ok := text.AvailableFilters[filterName]
-		if !ok {
-			slog.Error("Invalid Filter", slog.String("filterName", filterName))
-			panic(fmt.Sprint("No filter:", filterName))
-		}
+	<-ctx.Done()
+	slog.Info("Shutting down Penny")
 
-		cfg.filters = append(cfg.filters, filter)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to gracefully shutdown", slog.Any("error", err))
 	}
-
-	return cfg
-}
-
-func main() {
-	// TODO: setup config loading hierarchy
-	config := parseConfig("config.json")
-	if config.Port <= 0 {
-		config.Port = 8080
-	}
-
-	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
-	mux := api.NewMux(config.BaseUrl)
-
-	data.New("file:data.sqlite3")
-
-	slog.Info(fmt.Sprintf("Starting Penny on %s", addr))
-	slog.Info(http.ListenAndServe(addr, mux).Error())
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jpappel/penny/data"
+)
+
+const migrateUsage = `Usage: penny migrate <command> [version]
+
+Commands:
+  status          show applied and pending migrations
+  up [version]    apply migrations up to version, defaults to the latest
+  down [version]  revert migrations down to version, defaults to one step back`
+
+// Run the migrate subcommand, returning the exit code
+func runMigrate(dbFile string, args []string) int {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	ctx := context.Background()
+	db := data.NewConn(dbFile)
+	defer db.Close()
+
+	migrations, err := data.Migrations()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid migrations:", err)
+		return 1
+	}
+
+	current, err := data.SchemaVersion(ctx, db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read schema version:", err)
+		return 1
+	}
+
+	var target int
+	switch args[0] {
+	case "status":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		return migrateStatus(ctx, db, migrations, current)
+	case "up":
+		target = len(migrations)
+	case "down":
+		target = current - 1
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if len(args) == 2 {
+		target, err = strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid version:", args[1])
+			return 2
+		}
+	}
+
+	if (args[0] == "up" && target < current) || (args[0] == "down" && target > current) {
+		fmt.Fprintf(os.Stderr, "Cannot migrate %s from version %d to %d\n", args[0], current, target)
+		return 1
+	}
+
+	if err := data.Migrate(ctx, db, target); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Migrated from version %d to %d\n", current, target)
+	return 0
+}
+
+func migrateStatus(ctx context.Context, db *sql.DB, migrations []data.Migration, current int) int {
+	applied, err := data.AppliedMigrations(ctx, db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read applied migrations:", err)
+		return 1
+	}
+
+	fmt.Printf("Schema version %d, latest %d\n", current, len(migrations))
+	for _, m := range applied {
+		fmt.Printf("  applied  %04d_%s (%s)\n", m.Version, m.Name, m.Applied.Local().Format("2006-01-02 15:04:05 MST"))
+	}
+	for _, m := range migrations[min(current, len(migrations)):] {
+		fmt.Printf("  pending  %04d_%s\n", m.Version, m.Name)
+	}
+
+	if current > len(migrations) {
+		fmt.Fprintln(os.Stderr, data.ErrNewerSchema)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jpappel/penny/data"
+)
+
+// How often deleted comments are checked for purging
+const purgeInterval = time.Hour
+
+// Purge deleted comments older than window until ctx is done
+func purgeDeleted(ctx context.Context, pdb data.PennyDB, window time.Duration) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := pdb.PurgeDeletedComments(ctx, time.Now().Add(-window))
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Failed to purge deleted comments", slog.Any("error", err))
+		} else if n > 0 {
+			slog.Info("Purged deleted comments", slog.Int64("comments", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
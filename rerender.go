@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jpappel/penny/data"
+	"github.com/jpappel/penny/filters"
+)
+
+const rerenderUsage = `Usage: penny rerender
+
+Regenerate the html of every comment with the configured filters and markdown settings.`
+
+// Run the rerender subcommand, returning the exit code
+func runRerender(dbFile string, renderer filters.Renderer, args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, rerenderUsage)
+		return 2
+	}
+
+	pdb := data.New(dbFile)
+	defer pdb.Close()
+
+	n, err := pdb.RerenderComments(context.Background(), renderer.Render)
+	fmt.Printf("Rendered %d comments\n", n)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to render some comments:")
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}